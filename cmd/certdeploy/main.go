@@ -1,21 +1,70 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/oott123/certdeploy/pkg/certparser"
+	"github.com/oott123/certdeploy/pkg/config"
 	"github.com/oott123/certdeploy/pkg/deployer"
-	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+type target struct {
+	name   string
+	create func() (deployer.Deployer, error)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "deployers" {
+		printDeployers(os.Stdout)
+		return
+	}
+	watchMode := len(os.Args) > 1 && os.Args[1] == "watch"
+
+	configFile := flag.String("config", getEnv("CERT_CONFIG"), "config file declaring deployment targets")
+	dryRun := flag.Bool("dry-run", getEnv("CERT_DRY_RUN") == "true", "only list resources to be updated without changing anything")
+	timeout := flag.Duration("timeout", getDurationEnv("CERT_DEPLOY_TIMEOUT", 0), "overall timeout of deployment, no limit if zero")
+	output := flag.String("output", getEnv("CERT_OUTPUT"), "output format of results, table or json")
+	continueOnError := flag.Bool("continue-on-error", getEnv("CERT_CONTINUE_ON_ERROR") == "true", "try every matched resource even if some of them failed")
+	skipValidation := flag.Bool("skip-validation", getEnv("CERT_SKIP_VALIDATION") == "true", "deploy even if certificate is expired, not yet valid or in wrong order")
+	force := flag.Bool("force", getEnv("CERT_FORCE_DEPLOY") == "true", "deploy even if a resource already serves the same certificate")
+	concurrency := flag.Int("concurrency", getIntEnv("CERT_CONCURRENCY", 4), "resources of a target deployed at once")
+	maxAttempts := flag.Int("max-attempts", getIntEnv("CERT_MAX_ATTEMPTS", 3), "attempts of a vendor API request failed by throttling or server errors")
+	requestTimeout := flag.Duration("request-timeout", getDurationEnv("CERT_REQUEST_TIMEOUT", time.Minute), "timeout of every vendor API request, no limit if zero")
+	debounce := flag.Duration("debounce", getDurationEnv("CERT_WATCH_DEBOUNCE", 10*time.Second), "watch mode: time to wait after the last change of cert files before deploying")
+	maxBackoff := flag.Duration("max-backoff", getDurationEnv("CERT_WATCH_MAX_BACKOFF", time.Hour), "watch mode: maximum delay between retries of a failed deployment")
+	if watchMode {
+		_ = flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	if *output == "" {
+		*output = "table"
+	}
+	if *output != "table" && *output != "json" {
+		log.Fatalf("unknown output format %s, should be table or json", *output)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	options := deployer.Options{
+		RequestTimeout:  *requestTimeout,
+		MaxAttempts:     *maxAttempts,
+		Concurrency:     *concurrency,
+		ContinueOnError: *continueOnError,
+		Force:           *force,
+	}
+
 	certFile := getEnv("CERT_PATH", "LEGO_CERT_PATH")
 	keyFile := getEnv("CERT_KEY_PATH", "LEGO_CERT_KEY_PATH")
-	deployerName := getEnv("CERT_DEPLOYER")
-	if deployerName == "" {
-		deployerName = "aliyun"
-	}
 
 	if certFile == "" || keyFile == "" {
 		fmt.Println("no cert file and/or key file given")
@@ -23,33 +72,224 @@ func main() {
 		return
 	}
 
-	dp, err := deployer.Create(deployerName)
+	targets, err := loadTargets(*configFile, parseDeployerNames(getEnv("CERT_DEPLOYER")), options)
 	if err != nil {
-		log.Fatalf("failed to create deployer: %s", err)
+		log.Fatalf("failed to load targets: %s", err)
 	}
 
-	log.Printf("deploying cert %s, key %s using deployer: %s", certFile, keyFile, dp.Name())
+	targetNames := make([]string, 0, len(targets))
+	for _, t := range targets {
+		targetNames = append(targetNames, t.name)
+	}
+
+	// deployPair deploys a loaded certificate to all targets, timeout applies to every single run
+	deployPair := func(ctx context.Context, p *certPair) bool {
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		reports := deployTargets(ctx, targets, p, *dryRun)
+		err := printReports(os.Stdout, *output, reports)
+		if err != nil {
+			log.Printf("failed to print results: %s", err)
+		}
+		return printSummary(reports)
+	}
 
-	cert, err := ioutil.ReadFile(certFile)
+	if watchMode {
+		log.Printf("watching cert %s, key %s for targets: %s", certFile, keyFile, strings.Join(targetNames, ", "))
+		w := &watcher{
+			certFile: certFile,
+			keyFile:  keyFile,
+			debounce: *debounce,
+			backoff:  newBackoff(30*time.Second, *maxBackoff),
+			load: func() (*certPair, error) {
+				return loadCertPair(certFile, keyFile, *skipValidation)
+			},
+			deploy: deployPair,
+		}
+		err = w.run(ctx)
+		if err != nil {
+			log.Fatalf("failed to watch cert files: %s", err)
+		}
+		log.Println("stopped watching cert files")
+		return
+	}
+
+	log.Printf("deploying cert %s, key %s using targets: %s", certFile, keyFile, strings.Join(targetNames, ", "))
+
+	pair, err := loadCertPair(certFile, keyFile, *skipValidation)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	if !deployPair(ctx, pair) {
+		stop()
+		os.Exit(1)
+	}
+
+	log.Println("finished deploy cert")
+}
+
+// certPair is a certificate and its private key which are verified to be deployable
+type certPair struct {
+	cert    string
+	key     string
+	domains []string
+}
+
+func loadCertPair(certFile, keyFile string, skipValidation bool) (*certPair, error) {
+	cert, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cert file %s: %w", certFile, err)
+	}
+	key, err := os.ReadFile(keyFile)
 	if err != nil {
-		log.Fatalf("failed to read cert file %s: %s", certFile, err)
+		return nil, fmt.Errorf("failed to read key file %s: %w", keyFile, err)
 	}
-	key, err := ioutil.ReadFile(keyFile)
+
+	err = certparser.VerifyKeyPair(string(cert), string(key))
 	if err != nil {
-		log.Fatalf("failed to read key file %s: %s", keyFile, err)
+		return nil, fmt.Errorf("key file %s does not fit cert file %s: %w", keyFile, certFile, err)
+	}
+
+	if skipValidation {
+		log.Println("skipped certificate validation")
+	} else {
+		err = certparser.ValidateCertificates(string(cert), time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("cert file %s is invalid, set CERT_SKIP_VALIDATION=true to deploy anyway: %w", certFile, err)
+		}
 	}
 
 	domains, err := certparser.DomainsFromCert(string(cert))
 	if err != nil {
-		log.Fatalf("failed to parse domains from cert: %s", err)
+		return nil, fmt.Errorf("failed to parse domains from cert: %w", err)
 	}
+	return &certPair{cert: string(cert), key: string(key), domains: domains}, nil
+}
+
+func deployTargets(ctx context.Context, targets []target, p *certPair, dryRun bool) []report {
+	reports := make([]report, 0, len(targets))
+	for _, t := range targets {
+		r := deploy(ctx, t, p.domains, p.cert, p.key, dryRun)
+		if r.err != nil {
+			log.Printf("target %s failed: %s", t.name, r.err)
+			r.Error = r.err.Error()
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
 
-	err = dp.Deploy(domains, string(cert), string(key))
+// loadTargets loads targets from config file if given, otherwise from deployer names configured by environment variables.
+// names selects targets in config file when both of them are given.
+func loadTargets(configFile string, names []string, options deployer.Options) ([]target, error) {
+	if configFile == "" {
+		if len(names) == 0 {
+			names = []string{"aliyun"}
+		}
+		targets := make([]target, 0, len(names))
+		for _, name := range names {
+			targets = append(targets, target{name: name, create: func() (deployer.Deployer, error) {
+				return deployer.Create(name, options)
+			}})
+		}
+		return targets, nil
+	}
+
+	file, err := config.Load(configFile)
 	if err != nil {
-		log.Fatalf("failed to deploy: %s", err)
+		return nil, err
 	}
 
-	log.Println("finished deploy cert")
+	configTargets := make([]*config.Target, 0)
+	if len(names) == 0 {
+		for i := range file.Targets {
+			configTargets = append(configTargets, &file.Targets[i])
+		}
+	} else {
+		for _, name := range names {
+			t, found := file.Target(name)
+			if !found {
+				return nil, fmt.Errorf("no target named %s in config file %s", name, configFile)
+			}
+			configTargets = append(configTargets, t)
+		}
+	}
+	if len(configTargets) == 0 {
+		return nil, fmt.Errorf("no target declared in config file %s", configFile)
+	}
+
+	targets := make([]target, 0, len(configTargets))
+	for _, t := range configTargets {
+		targets = append(targets, target{name: t.Name, create: func() (deployer.Deployer, error) {
+			return deployer.CreateFromTarget(t, options)
+		}})
+	}
+	return targets, nil
+}
+
+func deploy(ctx context.Context, t target, domains []string, cert, key string, dryRun bool) report {
+	r := report{Target: t.name}
+	dp, err := t.create()
+	if err != nil {
+		r.err = fmt.Errorf("failed to create deployer: %w", err)
+		return r
+	}
+	r.Deployer = dp.Name()
+
+	if dryRun {
+		log.Printf("planning target %s using deployer: %s", t.name, dp.Name())
+		r.Plan, err = dp.Plan(ctx, domains, cert, key)
+		if err != nil {
+			r.err = fmt.Errorf("failed to plan: %w", err)
+		}
+		return r
+	}
+
+	log.Printf("deploying target %s using deployer: %s", t.name, dp.Name())
+	r.Result, err = dp.Deploy(ctx, domains, cert, key)
+	if err != nil {
+		r.err = fmt.Errorf("failed to deploy: %w", err)
+	}
+	return r
+}
+
+func parseDeployerNames(value string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := getEnv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid duration %s in %s: %s", value, key, err)
+	}
+	return duration
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	value := getEnv(key)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid number %s in %s: %s", value, key, err)
+	}
+	return i
 }
 
 func getEnv(keys ...string) string {
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
+
+	"github.com/oott123/certdeploy/pkg/deployer"
+)
+
+// report is the outcome of deploying or planning a single target
+type report struct {
+	Target   string           `json:"target"`
+	Deployer string           `json:"deployer,omitempty"`
+	Plan     *deployer.Plan   `json:"plan,omitempty"`
+	Result   *deployer.Result `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	err      error
+}
+
+func printReports(w io.Writer, format string, reports []report) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+	if format != "table" {
+		return fmt.Errorf("unknown output format %s", format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "TARGET\tRESOURCE\tACTION\tDETAIL")
+	for _, r := range reports {
+		rows := 0
+		if r.Plan != nil {
+			for _, item := range r.Plan.Items {
+				rows++
+				_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Target, item.Resource, item.Action, item.Reason)
+			}
+		}
+		if r.Result != nil {
+			for _, entry := range r.Result.Entries {
+				rows++
+				detail := ""
+				if entry.Err != nil {
+					detail = entry.Err.Error()
+				}
+				_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Target, entry.Resource, entry.Action, detail)
+			}
+		}
+		if r.err != nil {
+			_, _ = fmt.Fprintf(tw, "%s\t-\t%s\t%s\n", r.Target, deployer.ActionFailed, r.err)
+		} else if rows == 0 {
+			_, _ = fmt.Fprintf(tw, "%s\t-\t-\tnothing to update\n", r.Target)
+		}
+	}
+	return tw.Flush()
+}
+
+// printSummary logs the outcome of every target and reports whether all of them succeeded
+func printSummary(reports []report) bool {
+	ok := true
+	log.Println("deploy summary:")
+	for _, r := range reports {
+		if r.err != nil {
+			ok = false
+			if r.Result != nil && r.Result.Count(deployer.ActionNotAttempted) > 0 {
+				log.Printf("  %s: failed, %d not attempted: %s", r.Target, r.Result.Count(deployer.ActionNotAttempted), r.err)
+			} else {
+				log.Printf("  %s: failed: %s", r.Target, r.err)
+			}
+		} else if r.Result != nil {
+			log.Printf("  %s: ok, %d updated, %d skipped", r.Target, r.Result.Count(deployer.ActionUpdated),
+				r.Result.Count(deployer.ActionSkippedUnchanged)+r.Result.Count(deployer.ActionSkippedInactive))
+		} else {
+			log.Printf("  %s: ok", r.Target)
+		}
+	}
+	return ok
+}
+
+// printDeployers lists registered deployers with their config fields
+func printDeployers(w io.Writer) {
+	for _, r := range deployer.Registrations() {
+		_, _ = fmt.Fprintf(w, "%s - %s\n", r.Name, r.Description)
+		for _, field := range r.Schema {
+			_, _ = fmt.Fprintf(w, "    %s: %s\n", field.Name, field.Type)
+		}
+	}
+	_, _ = fmt.Fprintf(w, "other names are run as plugin executables named %s<name> in PATH\n", deployer.ExecPluginPrefix)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/oott123/certdeploy/pkg/deployer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintReports(t *testing.T) {
+	result := &deployer.Result{}
+	result.Updated("a.example.com")
+	result.Add("b.example.com", deployer.ActionSkippedInactive, nil)
+	result.Failed("c.example.com", errors.New("api error"))
+	reports := []report{
+		{Target: "aliyun", Deployer: "aliyun", Result: result, Error: "failed to deploy", err: errors.New("failed to deploy")},
+	}
+
+	var table bytes.Buffer
+	assert.NoError(t, printReports(&table, "table", reports))
+	assert.Contains(t, table.String(), "a.example.com  updated")
+	assert.Contains(t, table.String(), "c.example.com  failed            api error")
+
+	var out bytes.Buffer
+	assert.NoError(t, printReports(&out, "json", reports))
+	var decoded []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	entries := decoded[0]["result"].(map[string]interface{})["entries"].([]interface{})
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "failed", entries[2].(map[string]interface{})["action"])
+	assert.Equal(t, "api error", entries[2].(map[string]interface{})["error"])
+	assert.Equal(t, "failed to deploy", decoded[0]["error"])
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watcher deploys the certificate pair on start and whenever the cert or key file changes
+type watcher struct {
+	certFile string
+	keyFile  string
+	// debounce is the quiet period after the last change before the files are loaded
+	debounce time.Duration
+	backoff  *backoff
+	load     func() (*certPair, error)
+	// deploy returns whether all targets succeeded
+	deploy func(ctx context.Context, p *certPair) bool
+
+	// deployed is the checksum of the last pair deployed successfully
+	deployed [sha256.Size]byte
+}
+
+func (w *watcher) run(ctx context.Context) error {
+	certFile, err := filepath.Abs(w.certFile)
+	if err != nil {
+		return err
+	}
+	keyFile, err := filepath.Abs(w.keyFile)
+	if err != nil {
+		return err
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer fsw.Close()
+
+	// watch directories instead of files, so that files replaced by rename or symlink swap are noticed
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := fsw.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+
+	debounce := time.NewTimer(0)
+	defer debounce.Stop()
+	retry := time.NewTimer(0)
+	retry.Stop()
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			if name := filepath.Clean(event.Name); name != certFile && name != keyFile {
+				continue
+			}
+			// a new change supersedes a pending retry
+			retry.Stop()
+			debounce.Reset(w.debounce)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("file watcher error: %s", err)
+		case <-debounce.C:
+			w.attempt(ctx, retry)
+		case <-retry.C:
+			w.attempt(ctx, retry)
+		}
+	}
+}
+
+// attempt loads and deploys the pair, and schedules a retry on failure
+func (w *watcher) attempt(ctx context.Context, retry *time.Timer) {
+	p, err := w.load()
+	if err != nil {
+		// files might be half written, the next change triggers another attempt
+		log.Printf("waiting for a valid certificate pair: %s", err)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(p.cert + "\n" + p.key))
+	if sum == w.deployed {
+		log.Println("certificate pair unchanged since last deployment, skipped")
+		return
+	}
+
+	if !w.deploy(ctx, p) {
+		if ctx.Err() != nil {
+			return
+		}
+		delay := w.backoff.next()
+		log.Printf("deployment failed, retrying in %s", delay)
+		retry.Reset(delay)
+		return
+	}
+	w.deployed = sum
+	w.backoff.reset()
+	log.Println("deployment finished, waiting for certificate changes")
+}
+
+// backoff doubles the delay on every failure up to max
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if max < min {
+		max = min
+	}
+	return &backoff{min: min, max: max}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current = b.current * 2
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(time.Second, 5*time.Second)
+	assert.Equal(t, time.Second, b.next())
+	assert.Equal(t, 2*time.Second, b.next())
+	assert.Equal(t, 4*time.Second, b.next())
+	assert.Equal(t, 5*time.Second, b.next())
+	b.reset()
+	assert.Equal(t, time.Second, b.next())
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	write := func(file, content string) {
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	}
+	write(certFile, "cert-1")
+	write(keyFile, "key-1")
+
+	deployed := make(chan string, 10)
+	failures := 1
+	w := &watcher{
+		certFile: certFile,
+		keyFile:  keyFile,
+		debounce: 50 * time.Millisecond,
+		backoff:  newBackoff(50*time.Millisecond, time.Second),
+		// a pair is valid when the versions of cert and key are the same
+		load: func() (*certPair, error) {
+			cert, _ := os.ReadFile(certFile)
+			key, _ := os.ReadFile(keyFile)
+			if strings.TrimPrefix(string(cert), "cert-") != strings.TrimPrefix(string(key), "key-") {
+				return nil, fmt.Errorf("%s does not fit %s", key, cert)
+			}
+			return &certPair{cert: string(cert), key: string(key)}, nil
+		},
+		deploy: func(ctx context.Context, p *certPair) bool {
+			deployed <- p.cert
+			if failures > 0 {
+				failures--
+				return false
+			}
+			return true
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+
+	expect := func(cert string) {
+		select {
+		case got := <-deployed:
+			assert.Equal(t, cert, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for deployment of %s", cert)
+		}
+	}
+	expectNothing := func() {
+		select {
+		case got := <-deployed:
+			t.Fatalf("unexpected deployment of %s", got)
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	// deployed on start, then retried after failure
+	expect("cert-1")
+	expect("cert-1")
+	expectNothing()
+
+	// half written pair is not deployed
+	write(certFile, "cert-2")
+	expectNothing()
+
+	// renamed into place
+	tmp := filepath.Join(dir, "key.pem.tmp")
+	write(tmp, "key-2")
+	assert.NoError(t, os.Rename(tmp, keyFile))
+	expect("cert-2")
+	expectNothing()
+
+	// unrelated files are ignored
+	write(filepath.Join(dir, "other"), "x")
+	expectNothing()
+
+	cancel()
+	assert.NoError(t, <-done)
+}
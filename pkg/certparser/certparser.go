@@ -1,9 +1,16 @@
 package certparser
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
+	"time"
 )
 
 func CertificatesFromPEM(certPem string) (certs []*x509.Certificate, err error) {
@@ -51,6 +58,94 @@ func PrivateKeyFromPem(keyPem string) (interface{}, error) {
 	return nil, fmt.Errorf("unkown block type: %s", block.Type)
 }
 
+// VerifyKeyPair checks that the private key matches the leaf certificate, which is the first one in certPem
+func VerifyKeyPair(certPem, keyPem string) error {
+	certs, err := CertificatesFromPEM(certPem)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate found")
+	}
+	key, err := PrivateKeyFromPem(keyPem)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	var public crypto.PublicKey
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		public = k.Public()
+	case *ecdsa.PrivateKey:
+		public = k.Public()
+	case ed25519.PrivateKey:
+		public = k.Public()
+	default:
+		return fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	leafPublic, ok := certs[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !leafPublic.Equal(public) {
+		return fmt.Errorf("private key (%s) does not match certificate %s (%s)",
+			keyAlgorithm(key), certs[0].Subject.CommonName, certs[0].PublicKeyAlgorithm)
+	}
+	return nil
+}
+
+// ValidateCertificates checks that every certificate in certPem is valid at now, the leaf comes first,
+// and each certificate is signed by the next one in the chain
+func ValidateCertificates(certPem string, now time.Time) error {
+	certs, err := CertificatesFromPEM(certPem)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate found")
+	}
+
+	for i, cert := range certs {
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("certificate #%d (%s) is not valid until %s", i+1, cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("certificate #%d (%s) has expired at %s", i+1, cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	if len(certs) > 1 && certs[0].IsCA {
+		return fmt.Errorf("certificate #1 (%s) is a CA certificate, leaf certificate should come first", certs[0].Subject.CommonName)
+	}
+
+	for i := 0; i < len(certs)-1; i++ {
+		err := certs[i].CheckSignatureFrom(certs[i+1])
+		if err != nil {
+			return fmt.Errorf("certificate #%d (%s) is not signed by the next certificate #%d (%s), chain might be in wrong order: %w",
+				i+1, certs[i].Subject.CommonName, i+2, certs[i+1].Subject.CommonName, err)
+		}
+	}
+
+	return nil
+}
+
+// Fingerprint returns SHA-256 fingerprint of certificate in lower case hex
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func keyAlgorithm(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return "RSA"
+	case *ecdsa.PrivateKey:
+		return "ECDSA"
+	case ed25519.PrivateKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", key)
+	}
+}
+
 func DomainsFromCert(certPem string) ([]string, error) {
 	block, _ := pem.Decode([]byte(certPem))
 	if block == nil {
@@ -0,0 +1,152 @@
+package certparser
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func generateKey(t *testing.T, algorithm string) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func keyToPem(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func selfSignedPem(t *testing.T, key crypto.Signer, domains ...string) string {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestVerifyKeyPair(t *testing.T) {
+	for _, algorithm := range []string{"rsa", "ecdsa", "ed25519"} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := generateKey(t, algorithm)
+			cert := selfSignedPem(t, key, "example.com")
+
+			assert.NoError(t, VerifyKeyPair(cert, keyToPem(t, key)))
+
+			other := generateKey(t, algorithm)
+			assert.ErrorContains(t, VerifyKeyPair(cert, keyToPem(t, other)), "does not match")
+		})
+	}
+}
+
+func TestVerifyKeyPairTraditionalFormats(t *testing.T) {
+	rsaKey := generateKey(t, "rsa").(*rsa.PrivateKey)
+	rsaPem := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	assert.NoError(t, VerifyKeyPair(selfSignedPem(t, rsaKey, "example.com"), rsaPem))
+
+	ecKey := generateKey(t, "ecdsa").(*ecdsa.PrivateKey)
+	ecDer, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPem := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDer}))
+	assert.NoError(t, VerifyKeyPair(selfSignedPem(t, ecKey, "example.com"), ecPem))
+
+	assert.ErrorContains(t, VerifyKeyPair(selfSignedPem(t, rsaKey, "example.com"), ecPem), "does not match")
+}
+
+func TestVerifyKeyPairInvalidInput(t *testing.T) {
+	key := generateKey(t, "ecdsa")
+	assert.ErrorContains(t, VerifyKeyPair("", keyToPem(t, key)), "no certificate found")
+	assert.Error(t, VerifyKeyPair(selfSignedPem(t, key, "example.com"), "not a key"))
+}
+
+func signedPem(t *testing.T, template, parent *x509.Certificate, key, parentKey crypto.Signer) (string, *x509.Certificate) {
+	t.Helper()
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), cert
+}
+
+func caTemplate(name string, serial int64) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+}
+
+func TestValidateCertificates(t *testing.T) {
+	rootKey := generateKey(t, "ecdsa")
+	rootTemplate := caTemplate("root", 1)
+	rootPem, root := signedPem(t, rootTemplate, rootTemplate, rootKey, rootKey)
+
+	intermediateKey := generateKey(t, "ecdsa")
+	intermediatePem, intermediate := signedPem(t, caTemplate("intermediate", 2), root, intermediateKey, rootKey)
+
+	leafKey := generateKey(t, "ecdsa")
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafPem, _ := signedPem(t, leafTemplate, intermediate, leafKey, intermediateKey)
+
+	now := time.Now()
+	assert.NoError(t, ValidateCertificates(leafPem+intermediatePem, now))
+	assert.NoError(t, ValidateCertificates(leafPem+intermediatePem+rootPem, now))
+	assert.NoError(t, ValidateCertificates(leafPem, now))
+
+	assert.ErrorContains(t, ValidateCertificates(leafPem, now.Add(2*time.Hour)), "has expired")
+	assert.ErrorContains(t, ValidateCertificates(leafPem, now.Add(-2*time.Hour)), "is not valid until")
+	assert.ErrorContains(t, ValidateCertificates(intermediatePem+leafPem, now), "leaf certificate should come first")
+	assert.ErrorContains(t, ValidateCertificates(leafPem+rootPem+intermediatePem, now), "wrong order")
+	assert.ErrorContains(t, ValidateCertificates("", now), "no certificate found")
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the root of a certdeploy config file
+type File struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// Target is a named deployment target, its Config is decoded lazily into the typed config of its deployer
+type Target struct {
+	Name   string    `yaml:"name"`
+	Type   string    `yaml:"type"`
+	Config yaml.Node `yaml:"config"`
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)}`)
+
+// Load reads config file from path and validates targets in it
+func Load(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return Parse(content)
+}
+
+// Parse parses config file content and validates targets in it
+func Parse(content []byte) (*File, error) {
+	var file File
+	err := yaml.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i, target := range file.Targets {
+		if target.Name == "" {
+			return nil, fmt.Errorf("target #%d has no name", i+1)
+		}
+		if target.Type == "" {
+			return nil, fmt.Errorf("target %s has no type", target.Name)
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("duplicated target name %s", target.Name)
+		}
+		names[target.Name] = true
+	}
+
+	return &file, nil
+}
+
+// Target finds target by its name
+func (f *File) Target(name string) (*Target, bool) {
+	for i := range f.Targets {
+		if f.Targets[i].Name == name {
+			return &f.Targets[i], true
+		}
+	}
+	return nil, false
+}
+
+// Decode decodes target config into v, while ${ENV} references in string values are replaced by environment variables.
+// Unknown keys are rejected, a typo would leave the field empty otherwise.
+func (t *Target) Decode(v interface{}) error {
+	if t.Config.Kind == 0 {
+		return nil
+	}
+	node := expandEnv(t.Config)
+	// yaml.Node.Decode does not check unknown keys, only a decoder does
+	content, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Errorf("failed to decode config of target %s: %w", t.Name, err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode config of target %s: %w", t.Name, err)
+	}
+	return nil
+}
+
+func expandEnv(node yaml.Node) yaml.Node {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		node.Value = envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+		})
+	}
+	if len(node.Content) > 0 {
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for _, child := range node.Content {
+			expanded := expandEnv(*child)
+			content = append(content, &expanded)
+		}
+		node.Content = content
+	}
+	return node
+}
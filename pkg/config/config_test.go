@@ -0,0 +1,75 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Setenv("CERTDEPLOY_TEST_SECRET", "secret-from-env")
+
+	file, err := Parse([]byte(`
+targets:
+  - name: aliyun-main
+    type: aliyun
+    config:
+      access_key_id: inline-id
+      access_key_secret: ${CERTDEPLOY_TEST_SECRET}
+      update_only: true
+  - name: volc
+    type: volc
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, file.Targets, 2)
+
+	target, found := file.Target("aliyun-main")
+	if !assert.True(t, found) {
+		return
+	}
+	var c struct {
+		AccessKeyId     string `yaml:"access_key_id"`
+		AccessKeySecret string `yaml:"access_key_secret"`
+		UpdateOnly      bool   `yaml:"update_only"`
+	}
+	assert.NoError(t, target.Decode(&c))
+	assert.Equal(t, "inline-id", c.AccessKeyId)
+	assert.Equal(t, "secret-from-env", c.AccessKeySecret)
+	assert.True(t, c.UpdateOnly)
+
+	target, found = file.Target("volc")
+	if !assert.True(t, found) {
+		return
+	}
+	assert.NoError(t, target.Decode(&c))
+}
+
+func TestDecodeUnknownField(t *testing.T) {
+	file, err := Parse([]byte(`
+targets:
+  - name: aliyun-typo
+    type: aliyun
+    config:
+      acess_key_id: inline-id
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var c struct {
+		AccessKeyId string `yaml:"access_key_id"`
+	}
+	err = file.Targets[0].Decode(&c)
+	assert.ErrorContains(t, err, "target aliyun-typo")
+	assert.ErrorContains(t, err, "field acess_key_id not found")
+}
+
+func TestParseInvalidTargets(t *testing.T) {
+	_, err := Parse([]byte("targets:\n  - type: aliyun\n"))
+	assert.Error(t, err)
+	_, err = Parse([]byte("targets:\n  - name: a\n"))
+	assert.Error(t, err)
+	_, err = Parse([]byte("targets:\n  - {name: a, type: aliyun}\n  - {name: a, type: volc}\n"))
+	assert.Error(t, err)
+}
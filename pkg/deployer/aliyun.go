@@ -1,6 +1,7 @@
 package deployer
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -9,10 +10,21 @@ import (
 	cdn "github.com/alibabacloud-go/cdn-20180510/v5/client"
 	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
 	"github.com/alibabacloud-go/tea/tea"
+	"github.com/oott123/certdeploy/pkg/util"
 )
 
+type AliyunConfig struct {
+	AccessKeyId     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"access_key_secret"`
+	// UpdateOnly only updates certs for CDN domains with SSL enabled
+	UpdateOnly bool `yaml:"update_only"`
+	// ResourceGroup only updates certs for domains under this resource group if given
+	ResourceGroup string `yaml:"resource_group"`
+}
+
 type AliyunDeployer struct {
 	client        *cdn.Client
+	options       Options
 	updateOnly    bool
 	resourceGroup string
 }
@@ -21,14 +33,19 @@ func (*AliyunDeployer) Name() string {
 	return "aliyun"
 }
 
-// Deploy deploys cert and key to all related domains, while domains indicate the domains contains in certificate
-func (d *AliyunDeployer) Deploy(domains []string, cert, key string) error {
+// Plan finds all CDN domains matching domains contains in certificate
+func (d *AliyunDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	plan := &Plan{}
 	if len(domains) < 1 {
-		return nil
+		return plan, nil
+	}
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Println("getting aliyun CDN domains matching given certificates")
-	domainsToDeploy := make(map[string]bool)
+	seen := make(map[string]bool)
 	for _, domain := range domains {
 		normalizedDomain := normalizeWildcardDomain(domain)
 		matchType := "full_match"
@@ -47,19 +64,35 @@ func (d *AliyunDeployer) Deploy(domains []string, cert, key string) error {
 			if d.resourceGroup != "" {
 				request.ResourceGroupId = tea.String(d.resourceGroup)
 			}
-			cdnDomains, err := d.client.DescribeUserDomains(&request)
+			cdnDomains, err := retryRequest(ctx, d.options, func(ctx context.Context) (*cdn.DescribeUserDomainsResponse, error) {
+				return awaitContext(ctx, func() (*cdn.DescribeUserDomainsResponse, error) {
+					return d.client.DescribeUserDomains(&request)
+				})
+			})
 			if err != nil {
-				return fmt.Errorf("failed to describe user domains with suffix %s: %w", normalizedDomain, err)
+				return nil, fmt.Errorf("failed to describe user domains with suffix %s: %w", normalizedDomain, err)
 			}
 			for _, cdnDomain := range cdnDomains.Body.Domains.PageData {
-				if cdnDomain.DomainName != nil && d.checkDomainStatus(cdnDomain.DomainStatus) {
-					if d.updateOnly {
-						if *cdnDomain.SslProtocol == "on" {
-							domainsToDeploy[*cdnDomain.DomainName] = true
-						}
-					} else {
-						domainsToDeploy[*cdnDomain.DomainName] = true
+				if cdnDomain.DomainName == nil || seen[*cdnDomain.DomainName] {
+					continue
+				}
+				if !checkCovered(domains, *cdnDomain.DomainName, normalizedDomain) {
+					seen[*cdnDomain.DomainName] = true
+					continue
+				}
+				if !d.checkDomainStatus(cdnDomain.DomainStatus) {
+					seen[*cdnDomain.DomainName] = true
+					plan.Skip(*cdnDomain.DomainName, ActionSkippedInactive, fmt.Sprintf("matches %s but is %s", domain, *cdnDomain.DomainStatus))
+					continue
+				}
+				if d.updateOnly {
+					if *cdnDomain.SslProtocol == "on" {
+						seen[*cdnDomain.DomainName] = true
+						d.planDomain(ctx, plan, info, *cdnDomain.DomainName, fmt.Sprintf("matches %s and has SSL enabled", domain))
 					}
+				} else {
+					seen[*cdnDomain.DomainName] = true
+					d.planDomain(ctx, plan, info, *cdnDomain.DomainName, fmt.Sprintf("matches %s", domain))
 				}
 			}
 			if *cdnDomains.Body.TotalCount > (*cdnDomains.Body.PageSize * (*cdnDomains.Body.PageNumber)) {
@@ -70,51 +103,70 @@ func (d *AliyunDeployer) Deploy(domains []string, cert, key string) error {
 		}
 	}
 
-	log.Printf("got %d domains to deploy", len(domainsToDeploy))
+	log.Printf("got %d domains to deploy", len(plan.Resources()))
+	return plan, nil
+}
 
-	i := 0
-	domainsChunk := make([]string, 0)
-	for domain := range domainsToDeploy {
-		i++
-		domainsChunk = append(domainsChunk, domain)
-		if i >= 50 {
-			err := d.deployCert(domainsChunk, normalizeWildcardDomain(domains[0]), cert, key)
-			if err != nil {
-				return fmt.Errorf("failed to deploy cert: %w", err)
-			}
-			i = 0
-			domainsChunk = make([]string, 0)
-		}
+// Deploy deploys cert and key to all related domains, while domains indicate the domains contains in certificate
+func (d *AliyunDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan, err := d.Plan(ctx, domains, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+
+	err = deployEach(ctx, d.options, result, plan.Resources(), func(domain string) string { return domain }, func(ctx context.Context, domain string) error {
+		return d.deployCert(ctx, domain, cert, key)
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to deploy cert: %w", err)
 	}
-	if len(domainsChunk) > 0 {
-		err := d.deployCert(domainsChunk, normalizeWildcardDomain(domains[0]), cert, key)
+	return result, result.Err()
+}
+
+// planDomain adds domain to plan, unless it already serves the certificate
+func (d *AliyunDeployer) planDomain(ctx context.Context, plan *Plan, info *certificateInfo, domain, reason string) {
+	if !d.options.Force {
+		request := cdn.DescribeDomainCertificateInfoRequest{DomainName: tea.String(domain)}
+		resp, err := retryRequest(ctx, d.options, func(ctx context.Context) (*cdn.DescribeDomainCertificateInfoResponse, error) {
+			return awaitContext(ctx, func() (*cdn.DescribeDomainCertificateInfoResponse, error) {
+				return d.client.DescribeDomainCertificateInfo(&request)
+			})
+		})
 		if err != nil {
-			return fmt.Errorf("failed to deploy cert: %w", err)
+			log.Printf("failed to describe certificate of domain %s, assuming it changed: %s", domain, err)
+		} else if resp.Body != nil && resp.Body.CertInfos != nil {
+			for _, certInfo := range resp.Body.CertInfos.CertInfo {
+				if certInfo.ServerCertificate != nil && info.matchesPem(*certInfo.ServerCertificate) {
+					plan.Skip(domain, ActionSkippedUnchanged, info.unchangedReason())
+					return
+				}
+			}
 		}
 	}
-	return nil
+	plan.Add(domain, reason)
 }
 
 func (d *AliyunDeployer) checkDomainStatus(status *string) bool {
 	return *status == "online" || *status == "configuring"
 }
 
-func (d *AliyunDeployer) deployCert(cdnDomains []string, name, cert, key string) error {
-	for i, domain := range cdnDomains {
-		log.Printf("deploying cert for domain %s (%d of %d)", domain, i+1, len(cdnDomains))
-		request := cdn.SetCdnDomainSSLCertificateRequest{
-			DomainName:  tea.String(domain),
-			CertType:    tea.String("upload"),
-			SSLPub:      tea.String(cert),
-			SSLPri:      tea.String(key),
-			SSLProtocol: tea.String("on"),
-		}
-		_, err := d.client.SetCdnDomainSSLCertificate(&request)
-		if err != nil {
-			return fmt.Errorf("failed to call set cert api: %w", err)
-		}
+func (d *AliyunDeployer) deployCert(ctx context.Context, domain, cert, key string) error {
+	request := cdn.SetCdnDomainSSLCertificateRequest{
+		DomainName:  tea.String(domain),
+		CertType:    tea.String("upload"),
+		SSLPub:      tea.String(cert),
+		SSLPri:      tea.String(key),
+		SSLProtocol: tea.String("on"),
+	}
+	_, err := retryMutation(ctx, d.options, func(ctx context.Context) (*cdn.SetCdnDomainSSLCertificateResponse, error) {
+		return awaitContext(ctx, func() (*cdn.SetCdnDomainSSLCertificateResponse, error) {
+			return d.client.SetCdnDomainSSLCertificate(&request)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to call set cert api for %s: %w", domain, err)
 	}
-
 	return nil
 }
 
@@ -125,31 +177,61 @@ func min(x, y int) int {
 	return y
 }
 
-func normalizeWildcardDomain(domain string) string {
-	if strings.Index(domain, "*") == 0 {
-		return strings.ToLower(domain[1:])
-	} else {
-		return strings.ToLower(domain)
+// checkCovered checks whether domain found by a vendor suffix or fuzzy search for searchTerm is covered by certDomains,
+// since the search also returns domains like a.b.example.com which a wildcard certificate does not cover
+func checkCovered(certDomains []string, domain, searchTerm string) bool {
+	if util.MatchDomains(certDomains, domain) {
+		return true
+	}
+	reason := "wildcard only covers a single label"
+	if !strings.HasSuffix(util.NormalizeDomain(domain), searchTerm) {
+		reason = "not matching any domain"
 	}
+	log.Printf("rejected domain %s found by searching %s: %s in certificate (%s)", domain, searchTerm, reason, strings.Join(certDomains, ", "))
+	return false
+}
+
+// normalizeWildcardDomain turns a domain in certificate into a search term of vendor APIs,
+// wildcard domains become suffixes like .example.com
+func normalizeWildcardDomain(domain string) string {
+	return strings.TrimPrefix(util.NormalizeDomain(domain), "*")
 }
 
 var _ Deployer = (*AliyunDeployer)(nil)
 
-func CreateAliyunDeployer() (*AliyunDeployer, error) {
-	config := openapi.Config{
-		AccessKeyId:     tea.String(os.Getenv("ALIYUN_ACCESS_KEY_ID")),
-		AccessKeySecret: tea.String(os.Getenv("ALIYUN_ACCESS_KEY_SECRET")),
+func init() {
+	Register(Define("aliyun", "Aliyun CDN domains", AliyunConfigFromEnv, CreateAliyunDeployer))
+}
+
+func AliyunConfigFromEnv() AliyunConfig {
+	return AliyunConfig{
+		AccessKeyId:     os.Getenv("ALIYUN_ACCESS_KEY_ID"),
+		AccessKeySecret: os.Getenv("ALIYUN_ACCESS_KEY_SECRET"),
+		UpdateOnly:      os.Getenv("ALIYUN_CERT_UPDATE_ONLY") == "true",
+		ResourceGroup:   os.Getenv("ALIYUN_CERT_RESOURCE_GROUP"),
+	}
+}
+
+func CreateAliyunDeployer(config AliyunConfig, options Options) (*AliyunDeployer, error) {
+	sdkConfig := openapi.Config{
+		AccessKeyId:     tea.String(config.AccessKeyId),
+		AccessKeySecret: tea.String(config.AccessKeySecret),
+	}
+	if options.RequestTimeout > 0 {
+		sdkConfig.ConnectTimeout = tea.Int(int(options.RequestTimeout.Milliseconds()))
+		sdkConfig.ReadTimeout = tea.Int(int(options.RequestTimeout.Milliseconds()))
 	}
 
-	client, err := cdn.NewClient(&config)
+	client, err := cdn.NewClient(&sdkConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create aliyun sdk instance: %w", err)
 	}
 
 	deployer := AliyunDeployer{
 		client:        client,
-		updateOnly:    os.Getenv("ALIYUN_CERT_UPDATE_ONLY") == "true",
-		resourceGroup: os.Getenv("ALIYUN_CERT_RESOURCE_GROUP"),
+		options:       options,
+		updateOnly:    config.UpdateOnly,
+		resourceGroup: config.ResourceGroup,
 	}
 
 	return &deployer, nil
@@ -0,0 +1,15 @@
+package deployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCovered(t *testing.T) {
+	certDomains := []string{"example.com", "*.example.com"}
+	assert.True(t, checkCovered(certDomains, "www.example.com", ".example.com"))
+	assert.True(t, checkCovered(certDomains, "Example.com", "example.com"))
+	assert.False(t, checkCovered(certDomains, "a.b.example.com", ".example.com"))
+	assert.False(t, checkCovered(certDomains, "www.example.com.cn", ".example.com"))
+}
@@ -0,0 +1,437 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// awsCloudFrontRegion is the only region of ACM certificates CloudFront is able to use
+const awsCloudFrontRegion = "us-east-1"
+
+type AwsConfig struct {
+	// Region of ACM certificates, resolved from shared config if empty
+	Region string `yaml:"region"`
+	// AccessKeyId and SecretAccessKey are optional, default credential chain is used if empty
+	AccessKeyId     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// Endpoint overrides endpoint of ACM and CloudFront APIs
+	Endpoint string `yaml:"endpoint"`
+	// Match is full or any, see AzureMatchFull and AzureMatchAny, full if empty
+	Match string `yaml:"match"`
+	// CloudFront attaches the certificate to distributions whose aliases are covered, importing it to ACM in
+	// us-east-1 if none of existing certificates there fits
+	CloudFront bool `yaml:"cloudfront"`
+}
+
+type AwsDeployer struct {
+	acm *acm.Client
+	// cloudFrontAcm is the ACM client of us-east-1, the same as acm if region is us-east-1
+	cloudFrontAcm *acm.Client
+	cloudFront    *cloudfront.Client
+	options       Options
+	matchAny      bool
+}
+
+// awsDeployment is the ACM certificates to re-import and CloudFront distributions to attach certificate to
+type awsDeployment struct {
+	arns          []string
+	distributions []string
+}
+
+var _ Deployer = (*AwsDeployer)(nil)
+
+func init() {
+	Register(Define("aws", "AWS ACM certificates and CloudFront distributions", AwsConfigFromEnv, CreateAwsDeployer))
+}
+
+func (*AwsDeployer) Name() string {
+	return "aws"
+}
+
+// Plan finds ACM certificates to re-import and CloudFront distributions to attach the certificate to
+func (d *AwsDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	plan := &Plan{}
+	_, err := d.find(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Deploy re-imports certificates in place so that their ARNs and bindings are kept,
+// then attaches the certificate to CloudFront distributions not using any of them
+func (d *AwsDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan := &Plan{}
+	deployment, err := d.find(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	pems, err := splitPems(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deployEach(ctx, d.options, result, deployment.arns, func(arn string) string { return arn }, func(ctx context.Context, arn string) error {
+		_, err := d.importCertificate(ctx, d.acm, arn, pems)
+		if err != nil {
+			return fmt.Errorf("failed to re-import certificate %s: %w", arn, err)
+		}
+		return nil
+	})
+	if err != nil {
+		result.NotAttempted(plan)
+		return result, err
+	}
+	if len(deployment.distributions) == 0 {
+		return result, result.Err()
+	}
+
+	arn, err := d.cloudFrontCertificate(ctx, domains, cert, pems)
+	if err != nil {
+		err = fmt.Errorf("failed to prepare certificate for cloudfront: %w", err)
+		for _, id := range deployment.distributions {
+			result.Failed(awsDistributionResource(id), err)
+		}
+		return result, err
+	}
+	err = deployEach(ctx, d.options, result, deployment.distributions, awsDistributionResource, func(ctx context.Context, id string) error {
+		err := d.attachCertificate(ctx, id, arn)
+		if err != nil {
+			return fmt.Errorf("failed to update distribution %s: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, result.Err()
+}
+
+func (d *AwsDeployer) find(ctx context.Context, domains []string, cert string, plan *Plan) (*awsDeployment, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+	deployment := &awsDeployment{}
+
+	log.Println("finding imported ACM certificates covered by given certificate")
+	certificates, err := d.listCertificates(ctx, d.acm)
+	if err != nil {
+		return nil, err
+	}
+	// handled are certificates which will serve the new certificate after deployment, keyed by ARN
+	handled := make(map[string]bool)
+	for _, summary := range certificates {
+		arn := aws.ToString(summary.CertificateArn)
+		sans, err := d.certificateDomains(ctx, d.acm, summary)
+		if err != nil {
+			return nil, err
+		}
+		matched, reason := certificateCovers(d.matchAny, domains, sans)
+		if !matched {
+			log.Printf("leaving certificate %s untouched: %s", arn, reason)
+			continue
+		}
+		handled[arn] = true
+		if !d.options.Force {
+			same, err := d.servesCertificate(ctx, d.acm, arn, info)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				plan.Skip(arn, ActionSkippedUnchanged, info.unchangedReason())
+				continue
+			}
+		}
+		deployment.arns = append(deployment.arns, arn)
+		plan.Add(arn, reason)
+	}
+
+	if d.cloudFront == nil {
+		return deployment, nil
+	}
+	if d.acm != d.cloudFrontAcm {
+		// bindings of certificates in other regions are not used by cloudfront
+		handled = make(map[string]bool)
+	}
+	log.Println("finding cloudfront distributions with aliases covered by given certificate")
+	paginator := cloudfront.NewListDistributionsPaginator(d.cloudFront, &cloudfront.ListDistributionsInput{})
+	for paginator.HasMorePages() {
+		page, err := retryRequest(ctx, d.options, func(ctx context.Context) (*cloudfront.ListDistributionsOutput, error) {
+			return paginator.NextPage(ctx)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cloudfront distributions: %w", err)
+		}
+		if page.DistributionList == nil {
+			continue
+		}
+		for _, distribution := range page.DistributionList.Items {
+			id := aws.ToString(distribution.Id)
+			resource := awsDistributionResource(id)
+			if distribution.Aliases == nil || len(distribution.Aliases.Items) == 0 {
+				continue
+			}
+			matched, reason := certificateCovers(d.matchAny, domains, distribution.Aliases.Items)
+			if !matched {
+				log.Printf("leaving distribution %s untouched: %s", id, reason)
+				continue
+			}
+			var arn string
+			if distribution.ViewerCertificate != nil {
+				arn = aws.ToString(distribution.ViewerCertificate.ACMCertificateArn)
+			}
+			if handled[arn] {
+				log.Printf("distribution %s uses certificate %s which is re-imported", id, arn)
+				continue
+			}
+			if !aws.ToBool(distribution.Enabled) {
+				plan.Skip(resource, ActionSkippedInactive, fmt.Sprintf("%s but is disabled", reason))
+				continue
+			}
+			if arn != "" && !d.options.Force {
+				same, err := d.servesCertificate(ctx, d.cloudFrontAcm, arn, info)
+				if err != nil {
+					return nil, err
+				}
+				if same {
+					plan.Skip(resource, ActionSkippedUnchanged, info.unchangedReason())
+					continue
+				}
+			}
+			deployment.distributions = append(deployment.distributions, id)
+			plan.Add(resource, reason)
+		}
+	}
+	return deployment, nil
+}
+
+func awsDistributionResource(id string) string {
+	return "cloudfront/" + id
+}
+
+// listCertificates lists imported certificates, which are the only ones could be re-imported
+func (d *AwsDeployer) listCertificates(ctx context.Context, client *acm.Client) ([]acmtypes.CertificateSummary, error) {
+	paginator := acm.NewListCertificatesPaginator(client, &acm.ListCertificatesInput{
+		// only RSA 1024 and 2048 certificates are listed if key types are not given
+		Includes: &acmtypes.Filters{KeyTypes: acmtypes.KeyAlgorithm("").Values()},
+	})
+	certificates := make([]acmtypes.CertificateSummary, 0)
+	for paginator.HasMorePages() {
+		page, err := retryRequest(ctx, d.options, func(ctx context.Context) (*acm.ListCertificatesOutput, error) {
+			return paginator.NextPage(ctx)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list acm certificates: %w", err)
+		}
+		for _, summary := range page.CertificateSummaryList {
+			if summary.Type == acmtypes.CertificateTypeImported {
+				certificates = append(certificates, summary)
+			}
+		}
+	}
+	return certificates, nil
+}
+
+// certificateDomains describes the certificate if its summary does not contain all domains
+func (d *AwsDeployer) certificateDomains(ctx context.Context, client *acm.Client, summary acmtypes.CertificateSummary) ([]string, error) {
+	if !aws.ToBool(summary.HasAdditionalSubjectAlternativeNames) {
+		if len(summary.SubjectAlternativeNameSummaries) > 0 {
+			return summary.SubjectAlternativeNameSummaries, nil
+		}
+		return []string{aws.ToString(summary.DomainName)}, nil
+	}
+	described, err := retryRequest(ctx, d.options, func(ctx context.Context) (*acm.DescribeCertificateOutput, error) {
+		return client.DescribeCertificate(ctx, &acm.DescribeCertificateInput{CertificateArn: summary.CertificateArn})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe certificate %s: %w", aws.ToString(summary.CertificateArn), err)
+	}
+	return described.Certificate.SubjectAlternativeNames, nil
+}
+
+func (d *AwsDeployer) servesCertificate(ctx context.Context, client *acm.Client, arn string, info *certificateInfo) (bool, error) {
+	existing, err := retryRequest(ctx, d.options, func(ctx context.Context) (*acm.GetCertificateOutput, error) {
+		return client.GetCertificate(ctx, &acm.GetCertificateInput{CertificateArn: aws.String(arn)})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get certificate %s: %w", arn, err)
+	}
+	return info.matchesPem(aws.ToString(existing.Certificate)), nil
+}
+
+// importCertificate imports a new certificate if arn is empty, otherwise re-imports into it
+func (d *AwsDeployer) importCertificate(ctx context.Context, client *acm.Client, arn string, pems *filePems) (string, error) {
+	input := &acm.ImportCertificateInput{
+		Certificate: pems.leaf,
+		PrivateKey:  pems.key,
+	}
+	if len(pems.chain) > 0 {
+		input.CertificateChain = pems.chain
+	}
+	if arn != "" {
+		input.CertificateArn = aws.String(arn)
+	}
+	retry := retryRequest[*acm.ImportCertificateOutput]
+	if arn == "" {
+		// importing without arn creates a new certificate every time
+		retry = retryMutation[*acm.ImportCertificateOutput]
+	}
+	imported, err := retry(ctx, d.options, func(ctx context.Context) (*acm.ImportCertificateOutput, error) {
+		return client.ImportCertificate(ctx, input)
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(imported.CertificateArn), nil
+}
+
+// cloudFrontCertificate finds an imported certificate in us-east-1 with the same content, or imports a new one
+func (d *AwsDeployer) cloudFrontCertificate(ctx context.Context, domains []string, cert string, pems *filePems) (string, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return "", err
+	}
+	certificates, err := d.listCertificates(ctx, d.cloudFrontAcm)
+	if err != nil {
+		return "", err
+	}
+	for _, summary := range certificates {
+		sans, err := d.certificateDomains(ctx, d.cloudFrontAcm, summary)
+		if err != nil {
+			return "", err
+		}
+		if covered, _ := certificateCovers(false, domains, sans); !covered {
+			continue
+		}
+		arn := aws.ToString(summary.CertificateArn)
+		same, err := d.servesCertificate(ctx, d.cloudFrontAcm, arn, info)
+		if err != nil {
+			return "", err
+		}
+		if same {
+			log.Printf("using certificate %s in %s for cloudfront", arn, awsCloudFrontRegion)
+			return arn, nil
+		}
+	}
+
+	arn, err := d.importCertificate(ctx, d.cloudFrontAcm, "", pems)
+	if err != nil {
+		return "", fmt.Errorf("failed to import certificate to %s: %w", awsCloudFrontRegion, err)
+	}
+	log.Printf("imported certificate %s in %s for cloudfront", arn, awsCloudFrontRegion)
+	return arn, nil
+}
+
+func (d *AwsDeployer) attachCertificate(ctx context.Context, id, arn string) error {
+	current, err := retryRequest(ctx, d.options, func(ctx context.Context) (*cloudfront.GetDistributionConfigOutput, error) {
+		return d.cloudFront.GetDistributionConfig(ctx, &cloudfront.GetDistributionConfigInput{Id: aws.String(id)})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get distribution config: %w", err)
+	}
+	config := current.DistributionConfig
+	viewerCertificate := &cftypes.ViewerCertificate{
+		ACMCertificateArn:            aws.String(arn),
+		CloudFrontDefaultCertificate: aws.Bool(false),
+		SSLSupportMethod:             cftypes.SSLSupportMethodSniOnly,
+		MinimumProtocolVersion:       cftypes.MinimumProtocolVersionTLSv122021,
+	}
+	if config.ViewerCertificate != nil && !aws.ToBool(config.ViewerCertificate.CloudFrontDefaultCertificate) {
+		// keep protocol settings of distributions already serving custom certificates
+		if config.ViewerCertificate.SSLSupportMethod != "" {
+			viewerCertificate.SSLSupportMethod = config.ViewerCertificate.SSLSupportMethod
+		}
+		if config.ViewerCertificate.MinimumProtocolVersion != "" {
+			viewerCertificate.MinimumProtocolVersion = config.ViewerCertificate.MinimumProtocolVersion
+		}
+	}
+	config.ViewerCertificate = viewerCertificate
+
+	// a repeated update fails since etag is changed by the first one
+	_, err = retryMutation(ctx, d.options, func(ctx context.Context) (*cloudfront.UpdateDistributionOutput, error) {
+		return d.cloudFront.UpdateDistribution(ctx, &cloudfront.UpdateDistributionInput{
+			Id:                 aws.String(id),
+			IfMatch:            current.ETag,
+			DistributionConfig: config,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to call update distribution api: %w", err)
+	}
+	return nil
+}
+
+func AwsConfigFromEnv() AwsConfig {
+	return AwsConfig{
+		Region:     os.Getenv("AWS_REGION"),
+		Endpoint:   os.Getenv("AWS_ENDPOINT_URL"),
+		Match:      os.Getenv("AWS_CERT_MATCH"),
+		CloudFront: os.Getenv("AWS_CLOUDFRONT") == "true",
+	}
+}
+
+func CreateAwsDeployer(config AwsConfig, options Options) (*AwsDeployer, error) {
+	if config.Match != "" && config.Match != string(AzureMatchFull) && config.Match != string(AzureMatchAny) {
+		return nil, fmt.Errorf("unknown aws match policy %s, should be full or any", config.Match)
+	}
+
+	loadOptions := []func(*awsconfig.LoadOptions) error{
+		// requests are retried by retryRequest
+		awsconfig.WithRetryer(func() aws.Retryer { return aws.NopRetryer{} }),
+	}
+	if config.Region != "" {
+		loadOptions = append(loadOptions, awsconfig.WithRegion(config.Region))
+	}
+	if config.AccessKeyId != "" || config.SecretAccessKey != "" {
+		loadOptions = append(loadOptions, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(config.AccessKeyId, config.SecretAccessKey, "")))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("aws region is required")
+	}
+
+	endpoint := strings.TrimSuffix(config.Endpoint, "/")
+	acmClient := func(region string) *acm.Client {
+		return acm.NewFromConfig(cfg, func(o *acm.Options) {
+			o.Region = region
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		})
+	}
+	deployer := AwsDeployer{
+		acm:      acmClient(cfg.Region),
+		options:  options,
+		matchAny: config.Match == string(AzureMatchAny),
+	}
+	if config.CloudFront {
+		deployer.cloudFrontAcm = deployer.acm
+		if cfg.Region != awsCloudFrontRegion {
+			deployer.cloudFrontAcm = acmClient(awsCloudFrontRegion)
+		}
+		deployer.cloudFront = cloudfront.NewFromConfig(cfg, func(o *cloudfront.Options) {
+			o.Region = awsCloudFrontRegion
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		})
+	}
+	return &deployer, nil
+}
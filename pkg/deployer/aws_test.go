@@ -0,0 +1,202 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oott123/certdeploy/pkg/certparser"
+	"github.com/stretchr/testify/assert"
+)
+
+type awsStandInCertificate struct {
+	arn  string
+	pem  string
+	sans []string
+}
+
+type awsStandInDistribution struct {
+	id      string
+	aliases []string
+	arn     string
+	enabled bool
+	etag    int
+}
+
+// awsStandIn serves ACM JSON APIs of every region and CloudFront XML APIs
+type awsStandIn struct {
+	mu            sync.Mutex
+	certificates  map[string][]*awsStandInCertificate
+	distributions []*awsStandInDistribution
+	imports       int
+}
+
+func (s *awsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.HasPrefix(r.URL.Path, "/2020-05-31/distribution") {
+		s.serveCloudFront(w, r)
+		return
+	}
+	// credential scope is like AKID/20060102/us-east-1/acm/aws4_request
+	region := strings.Split(r.Header.Get("Authorization"), "/")[2]
+	var input struct {
+		CertificateArn string
+		Certificate    []byte
+		PrivateKey     []byte
+	}
+	_ = json.NewDecoder(r.Body).Decode(&input)
+	var output interface{}
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "CertificateManager.") {
+	case "ListCertificates":
+		summaries := make([]map[string]interface{}, 0)
+		for _, c := range s.certificates[region] {
+			summaries = append(summaries, map[string]interface{}{
+				"CertificateArn":                  c.arn,
+				"DomainName":                      c.sans[0],
+				"SubjectAlternativeNameSummaries": c.sans,
+				"Type":                            "IMPORTED",
+			})
+		}
+		output = map[string]interface{}{"CertificateSummaryList": summaries}
+	case "GetCertificate":
+		for _, c := range s.certificates[region] {
+			if c.arn == input.CertificateArn {
+				output = map[string]string{"Certificate": c.pem}
+			}
+		}
+	case "ImportCertificate":
+		s.imports++
+		sans, _ := certparser.DomainsFromCert(string(input.Certificate))
+		imported := &awsStandInCertificate{arn: input.CertificateArn, pem: string(input.Certificate), sans: sans}
+		if imported.arn == "" {
+			imported.arn = fmt.Sprintf("arn:aws:acm:%s:123456789012:certificate/new-%d", region, s.imports)
+		}
+		replaced := false
+		for i, c := range s.certificates[region] {
+			if c.arn == imported.arn {
+				s.certificates[region][i] = imported
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.certificates[region] = append(s.certificates[region], imported)
+		}
+		output = map[string]string{"CertificateArn": imported.arn}
+	}
+	if output == nil {
+		w.Header().Set("X-Amzn-ErrorType", "ResourceNotFoundException")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"not found"}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(output)
+}
+
+func (s *awsStandIn) serveCloudFront(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml")
+	if r.URL.Path == "/2020-05-31/distribution" {
+		items := ""
+		for _, d := range s.distributions {
+			aliases := ""
+			for _, alias := range d.aliases {
+				aliases += "<CNAME>" + alias + "</CNAME>"
+			}
+			items += fmt.Sprintf("<DistributionSummary><Id>%s</Id><Enabled>%t</Enabled>"+
+				"<Aliases><Quantity>%d</Quantity><Items>%s</Items></Aliases>"+
+				"<ViewerCertificate><ACMCertificateArn>%s</ACMCertificateArn></ViewerCertificate></DistributionSummary>",
+				d.id, d.enabled, len(d.aliases), aliases, d.arn)
+		}
+		_, _ = fmt.Fprintf(w, "<DistributionList><IsTruncated>false</IsTruncated><Quantity>%d</Quantity><Items>%s</Items></DistributionList>",
+			len(s.distributions), items)
+		return
+	}
+	id := strings.Split(r.URL.Path, "/")[3]
+	for _, d := range s.distributions {
+		if d.id != id {
+			continue
+		}
+		if r.Method == http.MethodPut {
+			if r.Header.Get("If-Match") != fmt.Sprint(d.etag) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				_, _ = w.Write([]byte("<ErrorResponse><Error><Code>PreconditionFailed</Code></Error></ErrorResponse>"))
+				return
+			}
+			var config struct {
+				ViewerCertificate struct {
+					ACMCertificateArn string
+					SSLSupportMethod  string
+				}
+			}
+			_ = xml.NewDecoder(r.Body).Decode(&config)
+			d.arn = config.ViewerCertificate.ACMCertificateArn
+			d.etag++
+		}
+		w.Header().Set("ETag", fmt.Sprint(d.etag))
+		_, _ = fmt.Fprintf(w, "<DistributionConfig><CallerReference>%s</CallerReference><Comment></Comment><Enabled>%t</Enabled>"+
+			"<Origins><Quantity>1</Quantity><Items><Origin><Id>origin</Id><DomainName>origin.example.com</DomainName></Origin></Items></Origins>"+
+			"<DefaultCacheBehavior><TargetOriginId>origin</TargetOriginId><ViewerProtocolPolicy>allow-all</ViewerProtocolPolicy></DefaultCacheBehavior>"+
+			"<ViewerCertificate><CloudFrontDefaultCertificate>true</CloudFrontDefaultCertificate></ViewerCertificate></DistributionConfig>",
+			d.id, d.enabled)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte("<ErrorResponse><Error><Code>NoSuchDistribution</Code></Error></ErrorResponse>"))
+}
+
+func TestAwsDeployer_Deploy(t *testing.T) {
+	domains := []string{"*.example.com", "example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	standIn := &awsStandIn{
+		certificates: map[string][]*awsStandInCertificate{
+			"eu-west-1": {
+				{arn: "arn:aws:acm:eu-west-1:123456789012:certificate/old", pem: testDomainsCertificatePem(t, "a.example.com"), sans: []string{"a.example.com"}},
+				{arn: "arn:aws:acm:eu-west-1:123456789012:certificate/other", pem: testDomainsCertificatePem(t, "other.com"), sans: []string{"other.com"}},
+				{arn: "arn:aws:acm:eu-west-1:123456789012:certificate/same", pem: cert, sans: domains},
+			},
+		},
+		distributions: []*awsStandInDistribution{
+			{id: "E1", aliases: []string{"www.example.com"}, enabled: true},
+			{id: "E2", aliases: []string{"www.other.com"}, enabled: true},
+			{id: "E3", aliases: []string{"b.example.com"}},
+		},
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	d, err := CreateAwsDeployer(AwsConfig{
+		Region:          "eu-west-1",
+		AccessKeyId:     "AKID",
+		SecretAccessKey: "SECRET",
+		Endpoint:        server.URL,
+		CloudFront:      true,
+	}, Options{MaxAttempts: 1})
+	assert.NoError(t, err)
+
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"arn:aws:acm:eu-west-1:123456789012:certificate/old", "cloudfront/E1"}, plan.Resources())
+	assert.Equal(t, 0, standIn.imports)
+
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	assert.Equal(t, 1, result.Count(ActionSkippedUnchanged))
+	assert.Equal(t, 1, result.Count(ActionSkippedInactive))
+	assert.Equal(t, cert, standIn.certificates["eu-west-1"][0].pem)
+	assert.Equal(t, "arn:aws:acm:us-east-1:123456789012:certificate/new-2", standIn.distributions[0].arn)
+	assert.Equal(t, "", standIn.distributions[1].arn)
+
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Count(ActionUpdated))
+	assert.Equal(t, 3, result.Count(ActionSkippedUnchanged))
+	assert.Equal(t, 2, standIn.imports)
+}
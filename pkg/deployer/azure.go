@@ -1,63 +1,148 @@
 package deployer
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
 	"github.com/oott123/certdeploy/pkg/certparser"
 	"github.com/oott123/certdeploy/pkg/util"
 	"golang.org/x/exp/slices"
-	"golang.org/x/net/context"
 	"log"
 	"os"
+	"strings"
+)
+
+type AzureConfig struct {
+	// KeyVaultUri is likely https://SOMETHING.vault.azure.net/
+	KeyVaultUri string `yaml:"key_vault_uri"`
+	// Match is the policy selecting certificates to update, see AzureMatchPolicy
+	Match AzureMatchPolicy `yaml:"match"`
+	// Names are the certificates to update with AzureMatchNames policy
+	Names []string `yaml:"names"`
+	// TenantId, ClientId and ClientSecret are credentials of a service principal.
+	// DefaultAzureCredential is used if they are empty, which reads AZURE_TENANT_ID and so on from environment.
+	TenantId     string `yaml:"tenant_id"`
+	ClientId     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+// AzureMatchPolicy decides which certificates in keyvault are updated
+type AzureMatchPolicy string
+
+const (
+	// AzureMatchFull updates certificates whose domains are all covered by the new certificate
+	AzureMatchFull AzureMatchPolicy = "full"
+	// AzureMatchAny updates certificates with at least one domain covered by the new certificate
+	AzureMatchAny AzureMatchPolicy = "any"
+	// AzureMatchNames updates certificates listed in names regardless of their domains
+	AzureMatchNames AzureMatchPolicy = "names"
 )
 
 type AzureDeployer struct {
-	client *azcertificates.Client
+	client  *azcertificates.Client
+	options Options
+	match   AzureMatchPolicy
+	names   []string
+}
+
+// azureCertificate is the current version of a certificate in keyvault
+type azureCertificate struct {
+	domains []string
+	pem     string
 }
 
 var _ Deployer = (*AzureDeployer)(nil)
 
+func init() {
+	Register(Define("azure", "Azure KeyVault certificates", AzureConfigFromEnv, CreateAzureDeployer))
+}
+
 func (*AzureDeployer) Name() string {
 	return "azure"
 }
 
-// Deploy deploys cert and key to all related domains, while domains indicate the domains contains in certificate
-func (d *AzureDeployer) Deploy(domains []string, cert, key string) error {
+// Plan finds all certificates in keyvault which are going to be updated
+func (d *AzureDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
 	log.Printf("finding certificates in keyvault to deploy")
-	certsDomainsMap, err := d.getCertificatesDomainsMap()
+	certsMap, err := d.getCertificatesMap(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get certs domains map: %w", err)
+		return nil, fmt.Errorf("failed to get certs domains map: %w", err)
 	}
-	found := false
-	for name, domainsInService := range *certsDomainsMap {
-		for _, domainInService := range domainsInService {
-			if !slices.Contains(domains, domainInService) {
-				continue
-			}
+	names := d.names
+	if d.match != AzureMatchNames {
+		names = make([]string, 0, len(*certsMap))
+		for name := range *certsMap {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+
+	plan := &Plan{}
+	for _, name := range names {
+		certInService, found := (*certsMap)[name]
+		if !found {
+			return nil, fmt.Errorf("certificate %s not found in keyvault", name)
+		}
+		matched, reason := azureCertificateMatches(d.match, domains, certInService.domains)
+		if !matched {
+			log.Printf("leaving certificate %s untouched: %s", name, reason)
+			continue
 		}
-		found = true
-		log.Printf("importing certificate to update %s", name)
-		err = d.importCertificate(name, cert, key)
+		if !d.options.Force && info.matchesPem(certInService.pem) {
+			plan.Skip(name, ActionSkippedUnchanged, info.unchangedReason())
+			continue
+		}
+		plan.Add(name, reason)
+	}
+	return plan, nil
+}
+
+// azureCertificateMatches checks whether a certificate in keyvault should be replaced by a certificate for certDomains
+func azureCertificateMatches(policy AzureMatchPolicy, certDomains, domainsInService []string) (bool, string) {
+	if policy == AzureMatchNames {
+		return true, fmt.Sprintf("listed by name, existing certificate for %s", strings.Join(domainsInService, ", "))
+	}
+	return certificateCovers(policy == AzureMatchAny, certDomains, domainsInService)
+}
+
+// Deploy deploys cert and key to all related domains, while domains indicate the domains contains in certificate
+func (d *AzureDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan, err := d.Plan(ctx, domains, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	err = deployEach(ctx, d.options, result, plan.Resources(), func(name string) string { return name }, func(ctx context.Context, name string) error {
+		err := d.importCertificate(ctx, name, cert, key)
 		if err != nil {
 			return fmt.Errorf("failed to import certificate: %w", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return result, err
 	}
-	if !found {
+	if len(plan.Items) == 0 {
 		log.Printf("unable to find certificates in keyvault to deploy")
 	}
-	return nil
+	return result, result.Err()
 }
 
-func (d *AzureDeployer) importCertificate(name, cert, key string) error {
+func (d *AzureDeployer) importCertificate(ctx context.Context, name, cert, key string) error {
 	pfx, err := util.PemToPfx(cert, key)
 	if err != nil {
 		return fmt.Errorf("failed to convert pem to pfx: %w", err)
 	}
 	encodedCertificate := base64.StdEncoding.EncodeToString(pfx)
 	contentType := "application/x-pkcs12"
-	_, err = d.client.ImportCertificate(context.Background(), name, azcertificates.ImportCertificateParameters{
+	parameters := azcertificates.ImportCertificateParameters{
 		Base64EncodedCertificate: &encodedCertificate,
 		CertificateAttributes:    nil,
 		CertificatePolicy: &azcertificates.CertificatePolicy{
@@ -73,27 +158,33 @@ func (d *AzureDeployer) importCertificate(name, cert, key string) error {
 		},
 		Password: nil,
 		Tags:     nil,
-	}, nil)
+	}
+	// every import creates a new version of certificate
+	_, err = retryMutation(ctx, d.options, func(ctx context.Context) (azcertificates.ImportCertificateResponse, error) {
+		return d.client.ImportCertificate(ctx, name, parameters, nil)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to volcRequest import certificate to %s: %w", name, err)
 	}
 	return nil
 }
 
-func (d *AzureDeployer) getCertificatesDomainsMap() (*map[string][]string, error) {
+func (d *AzureDeployer) getCertificatesMap(ctx context.Context) (*map[string]azureCertificate, error) {
 	pager := d.client.NewListCertificatesPager(nil)
-	certsDomainsMap := make(map[string][]string)
+	certsMap := make(map[string]azureCertificate)
 	for pager.More() {
-		page, err := pager.NextPage(context.Background())
+		page, err := retryRequest(ctx, d.options, pager.NextPage)
 		if err != nil {
 			return nil, fmt.Errorf("failed to naviate to next page: %w", err)
 		}
 		for _, cert := range page.Value {
 			name := cert.ID.Name()
-			if _, found := certsDomainsMap[name]; found {
+			if _, found := certsMap[name]; found {
 				continue
 			}
-			certDetails, err := d.client.GetCertificate(context.Background(), name, cert.ID.Version(), nil)
+			certDetails, err := retryRequest(ctx, d.options, func(ctx context.Context) (azcertificates.GetCertificateResponse, error) {
+				return d.client.GetCertificate(ctx, name, cert.ID.Version(), nil)
+			})
 			if err != nil {
 				return nil, fmt.Errorf("failed to get certificate %s: %w", name, err)
 			}
@@ -103,24 +194,68 @@ func (d *AzureDeployer) getCertificatesDomainsMap() (*map[string][]string, error
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse certificate %s: %w", name, err)
 			}
-			certsDomainsMap[name] = domains
+			certsMap[name] = azureCertificate{domains: domains, pem: pem}
 		}
 	}
-	return &certsDomainsMap, nil
+	return &certsMap, nil
+}
+
+// azureCredential uses the service principal in config if given, so that every target can have its own account
+func azureCredential(config AzureConfig) (azcore.TokenCredential, error) {
+	if config.TenantId == "" && config.ClientId == "" && config.ClientSecret == "" {
+		return azidentity.NewDefaultAzureCredential(nil)
+	}
+	if config.TenantId == "" || config.ClientId == "" || config.ClientSecret == "" {
+		return nil, fmt.Errorf("tenant_id, client_id and client_secret are all required for a service principal")
+	}
+	return azidentity.NewClientSecretCredential(config.TenantId, config.ClientId, config.ClientSecret, nil)
 }
 
-func CreateAzureDeployer() (*AzureDeployer, error) {
-	keyVaultUri := os.Getenv("AZURE_KEY_VAULT_URI")
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+func AzureConfigFromEnv() AzureConfig {
+	config := AzureConfig{
+		KeyVaultUri: os.Getenv("AZURE_KEY_VAULT_URI"),
+		Match:       AzureMatchPolicy(os.Getenv("AZURE_CERT_MATCH")),
+	}
+	if names := os.Getenv("AZURE_CERT_NAMES"); names != "" {
+		config.Names = strings.Split(names, ",")
+	}
+	return config
+}
+
+func CreateAzureDeployer(config AzureConfig, options Options) (*AzureDeployer, error) {
+	match := config.Match
+	if match == "" {
+		match = AzureMatchFull
+		if len(config.Names) > 0 {
+			match = AzureMatchNames
+		}
+	}
+	if match != AzureMatchFull && match != AzureMatchAny && match != AzureMatchNames {
+		return nil, fmt.Errorf("unknown azure match policy %s, should be full, any or names", match)
+	}
+	names := make([]string, 0, len(config.Names))
+	for _, name := range config.Names {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if match == AzureMatchNames && len(names) == 0 {
+		return nil, fmt.Errorf("azure match policy names requires certificate names")
+	}
+
+	cred, err := azureCredential(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get azure credentials: %w", err)
 	}
-	client, err := azcertificates.NewClient(keyVaultUri, cred, nil)
+	client, err := azcertificates.NewClient(config.KeyVaultUri, cred, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create azure certificate client: %w", err)
 	}
 	deployer := AzureDeployer{
-		client: client,
+		client:  client,
+		options: options,
+		match:   match,
+		names:   names,
 	}
 	return &deployer, nil
 }
@@ -0,0 +1,40 @@
+package deployer
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureCertificateMatches(t *testing.T) {
+	certDomains := []string{"example.com", "*.example.com"}
+
+	cases := []struct {
+		policy           AzureMatchPolicy
+		domainsInService []string
+		matched          bool
+	}{
+		{AzureMatchFull, []string{"example.com"}, true},
+		{AzureMatchFull, []string{"example.com", "www.example.com"}, true},
+		{AzureMatchFull, []string{"example.com", "other.com"}, false},
+		{AzureMatchFull, []string{"a.b.example.com"}, false},
+		{AzureMatchFull, []string{}, false},
+		{AzureMatchAny, []string{"example.com", "other.com"}, true},
+		{AzureMatchAny, []string{"other.com"}, false},
+		{AzureMatchNames, []string{"other.com"}, true},
+	}
+	for _, c := range cases {
+		matched, reason := azureCertificateMatches(c.policy, certDomains, c.domainsInService)
+		assert.Equal(t, c.matched, matched, "%s %v: %s", c.policy, c.domainsInService, reason)
+	}
+}
+
+func TestAzureCredential(t *testing.T) {
+	cred, err := azureCredential(AzureConfig{TenantId: "tenant", ClientId: "client", ClientSecret: "secret"})
+	assert.NoError(t, err)
+	assert.IsType(t, &azidentity.ClientSecretCredential{}, cred)
+
+	_, err = azureCredential(AzureConfig{TenantId: "tenant", ClientId: "client"})
+	assert.ErrorContains(t, err, "client_secret are all required")
+}
@@ -0,0 +1,382 @@
+package deployer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/oott123/certdeploy/pkg/util"
+)
+
+// baiduCloudSignatureExpiration is the seconds a signature stays valid
+const baiduCloudSignatureExpiration = 1800
+
+type BaiduCloudConfig struct {
+	AccessKeyId     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// UpdateOnly only updates certs for CDN domains with HTTPS enabled
+	UpdateOnly bool `yaml:"update_only"`
+}
+
+type BaiduCloudDeployer struct {
+	cert       *resty.Client
+	cdn        *resty.Client
+	options    Options
+	updateOnly bool
+}
+
+type baiduCloudDomain struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type baiduCloudCertificate struct {
+	CertId   string `json:"certId"`
+	CertName string `json:"certName"`
+	// CertStopTime is like 2006-01-02T15:04:05Z
+	CertStopTime string `json:"certStopTime"`
+}
+
+type baiduCloudError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"requestId"`
+}
+
+// baiduCloudTarget is a CDN domain to deploy and its current https config.
+// The config is kept as is, since fields not sent back are reset by the API.
+type baiduCloudTarget struct {
+	name  string
+	https map[string]interface{}
+}
+
+// baiduCloudTargets is the domains to deploy, and the uploaded certificate id if it is already uploaded
+type baiduCloudTargets struct {
+	certId  string
+	domains []baiduCloudTarget
+}
+
+var _ Deployer = (*BaiduCloudDeployer)(nil)
+
+func init() {
+	Register(Define("baiducloud", "Baidu Cloud CDN domains", BaiduCloudConfigFromEnv, CreateBaiduCloudDeployer))
+}
+
+func (*BaiduCloudDeployer) Name() string {
+	return "baiducloud"
+}
+
+// Plan finds all CDN domains matching domains contains in certificate
+func (d *BaiduCloudDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	plan := &Plan{}
+	_, err := d.findTargets(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Deploy uploads the certificate to certificate management unless it is already uploaded, then binds it to related domains
+func (d *BaiduCloudDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan := &Plan{}
+	targets, err := d.findTargets(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	if len(targets.domains) == 0 {
+		log.Printf("unable to find domains suited for certificate")
+		return result, nil
+	}
+
+	certId := targets.certId
+	if certId == "" {
+		certId, err = d.uploadCertificate(ctx, cert, key)
+		if err != nil {
+			err = fmt.Errorf("failed to upload certificate: %w", err)
+			for _, domain := range targets.domains {
+				result.Failed(domain.name, err)
+			}
+			return result, err
+		}
+	}
+
+	err = deployEach(ctx, d.options, result, targets.domains, func(domain baiduCloudTarget) string { return domain.name }, func(ctx context.Context, domain baiduCloudTarget) error {
+		https := make(map[string]interface{}, len(domain.https)+2)
+		for k, v := range domain.https {
+			https[k] = v
+		}
+		https["enabled"] = true
+		https["certId"] = certId
+		err := d.request(ctx, d.cdn, http.MethodPut, "/v2/domain/"+url.PathEscape(domain.name)+"/config", "https",
+			map[string]interface{}{"https": https}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to update https config of %s: %w", domain.name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, result.Err()
+}
+
+func (d *BaiduCloudDeployer) findTargets(ctx context.Context, domains []string, cert string, plan *Plan) (*baiduCloudTargets, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+	certId, err := d.findCertificate(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	targets := &baiduCloudTargets{certId: certId}
+
+	log.Println("getting baidu cloud CDN domains matching given certificates")
+	marker := ""
+	for {
+		var page struct {
+			Domains     []baiduCloudDomain `json:"domains"`
+			IsTruncated bool               `json:"isTruncated"`
+			NextMarker  string             `json:"nextMarker"`
+		}
+		err := d.request(ctx, d.cdn, http.MethodGet, "/v2/domain", "marker="+baiduCloudEscape(marker), nil, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list domains: %w", err)
+		}
+		for _, cdnDomain := range page.Domains {
+			if !util.MatchDomains(domains, cdnDomain.Name) {
+				continue
+			}
+			if cdnDomain.Status != "RUNNING" {
+				plan.Skip(cdnDomain.Name, ActionSkippedInactive, fmt.Sprintf("matches certificate but is %s", strings.ToLower(cdnDomain.Status)))
+				continue
+			}
+			var config struct {
+				Https map[string]interface{} `json:"https"`
+			}
+			err := d.request(ctx, d.cdn, http.MethodGet, "/v2/domain/"+url.PathEscape(cdnDomain.Name)+"/config", "", nil, &config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get config of domain %s: %w", cdnDomain.Name, err)
+			}
+			enabled, _ := config.Https["enabled"].(bool)
+			if d.updateOnly && !enabled {
+				plan.Skip(cdnDomain.Name, ActionSkippedInactive, "matches certificate but has HTTPS disabled")
+				continue
+			}
+			if !d.options.Force && certId != "" && enabled && config.Https["certId"] == certId {
+				plan.Skip(cdnDomain.Name, ActionSkippedUnchanged, info.unchangedReason())
+				continue
+			}
+			targets.domains = append(targets.domains, baiduCloudTarget{name: cdnDomain.Name, https: config.Https})
+			if enabled {
+				plan.Add(cdnDomain.Name, "matches certificate and has HTTPS enabled")
+			} else {
+				plan.Add(cdnDomain.Name, "matches certificate")
+			}
+		}
+		if !page.IsTruncated || page.NextMarker == "" {
+			break
+		}
+		marker = page.NextMarker
+	}
+	log.Printf("got %d domains to deploy", len(targets.domains))
+	return targets, nil
+}
+
+// findCertificate finds an uploaded certificate with the same content, so that it is not uploaded again
+func (d *BaiduCloudDeployer) findCertificate(ctx context.Context, info *certificateInfo) (string, error) {
+	var list struct {
+		Certs []baiduCloudCertificate `json:"certs"`
+	}
+	err := d.request(ctx, d.cert, http.MethodGet, "/v1/certificate", "", nil, &list)
+	if err != nil {
+		return "", fmt.Errorf("failed to list certificates: %w", err)
+	}
+	for _, c := range list.Certs {
+		stopTime, err := time.Parse(time.RFC3339, c.CertStopTime)
+		if err != nil || !info.matchesExpiry(stopTime) {
+			continue
+		}
+		var raw struct {
+			CertServerData string `json:"certServerData"`
+		}
+		err = d.request(ctx, d.cert, http.MethodGet, "/v1/certificate/"+url.PathEscape(c.CertId)+"/rawData", "", nil, &raw)
+		if err != nil {
+			return "", fmt.Errorf("failed to get certificate %s: %w", c.CertId, err)
+		}
+		if info.matchesPem(raw.CertServerData) {
+			log.Printf("found uploaded certificate %s", c.CertId)
+			return c.CertId, nil
+		}
+	}
+	return "", nil
+}
+
+func (d *BaiduCloudDeployer) uploadCertificate(ctx context.Context, cert, key string) (string, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return "", err
+	}
+	pems, err := splitPems(cert, key)
+	if err != nil {
+		return "", err
+	}
+	request := map[string]string{
+		// name only allows letters, digits and -_/.
+		"certName":        fmt.Sprintf("certdeploy-%s-%s", strings.ReplaceAll(info.primaryName(), "*", "_"), time.Now().UTC().Format("20060102")),
+		"certServerData":  string(pems.leaf),
+		"certPrivateData": string(pems.key),
+	}
+	if len(pems.chain) > 0 {
+		request["certLinkData"] = string(pems.chain)
+	}
+	var uploaded struct {
+		CertId string `json:"certId"`
+	}
+	err = d.request(ctx, d.cert, http.MethodPost, "/v1/certificate", "", request, &uploaded)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("uploaded cert id %s", uploaded.CertId)
+	return uploaded.CertId, nil
+}
+
+// request calls API with raw query, which is kept as is since some APIs take a bare key like ?https
+func (d *BaiduCloudDeployer) request(ctx context.Context, client *resty.Client, method, path, query string, body interface{}, result interface{}) error {
+	if query != "" {
+		path += "?" + query
+	}
+	resp, err := retryHttp(method)(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+		req := client.R().SetContext(ctx)
+		if body != nil {
+			req.SetBody(body)
+		}
+		if result != nil {
+			req.SetResult(result)
+		}
+		return checkRestyResponse(req.Execute(method, path))
+	})
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		var e baiduCloudError
+		_ = client.JSONUnmarshal(resp.Body(), &e)
+		return fmt.Errorf("baidu cloud returns %s: [%s] %s (request id %s)", resp.Status(), e.Code, e.Message, e.RequestId)
+	}
+	return nil
+}
+
+// baiduCloudSign signs request with BCE v1 signature, host, content headers and x-bce-* headers are signed
+func baiduCloudSign(r *http.Request, accessKeyId, secretAccessKey string, now time.Time) {
+	timestamp := now.UTC().Format("2006-01-02T15:04:05Z")
+	r.Header.Set("X-Bce-Date", timestamp)
+	authPrefix := fmt.Sprintf("bce-auth-v1/%s/%s/%d", accessKeyId, timestamp, baiduCloudSignatureExpiration)
+	signingKey := hex.EncodeToString(baiduCloudHmac([]byte(secretAccessKey), authPrefix))
+
+	query := r.URL.Query()
+	queryParts := make([]string, 0, len(query))
+	for k, values := range query {
+		if strings.ToLower(k) == "authorization" {
+			continue
+		}
+		for _, v := range values {
+			queryParts = append(queryParts, baiduCloudEscape(k)+"="+baiduCloudEscape(v))
+		}
+	}
+	sort.Strings(queryParts)
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		segments[i] = baiduCloudEscape(segment)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, values := range r.Header {
+		k = strings.ToLower(k)
+		if k == "content-type" || k == "content-length" || k == "content-md5" ||
+			strings.HasPrefix(k, "x-bce-") && k != "x-bce-request-id" {
+			headers[k] = strings.TrimSpace(values[0])
+		}
+	}
+	signed := make([]string, 0, len(headers))
+	canonicalHeaders := make([]string, 0, len(headers))
+	for k, v := range headers {
+		signed = append(signed, k)
+		canonicalHeaders = append(canonicalHeaders, baiduCloudEscape(k)+":"+baiduCloudEscape(v))
+	}
+	sort.Strings(signed)
+	sort.Strings(canonicalHeaders)
+	canonicalRequest := strings.Join([]string{
+		r.Method, strings.Join(segments, "/"), strings.Join(queryParts, "&"), strings.Join(canonicalHeaders, "\n"),
+	}, "\n")
+
+	signature := hex.EncodeToString(baiduCloudHmac([]byte(signingKey), canonicalRequest))
+	r.Header.Set("Authorization", authPrefix+"/"+strings.Join(signed, ";")+"/"+signature)
+}
+
+func baiduCloudHmac(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// baiduCloudEscape encodes everything except unreserved characters of RFC 3986
+func baiduCloudEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func BaiduCloudConfigFromEnv() BaiduCloudConfig {
+	return BaiduCloudConfig{
+		AccessKeyId:     os.Getenv("BAIDUCLOUD_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("BAIDUCLOUD_SECRET_ACCESS_KEY"),
+		UpdateOnly:      os.Getenv("BAIDUCLOUD_CERT_UPDATE_ONLY") == "true",
+	}
+}
+
+func CreateBaiduCloudDeployer(config BaiduCloudConfig, options Options) (*BaiduCloudDeployer, error) {
+	return newBaiduCloudDeployer(config, "https://certificate.baidubce.com", "https://cdn.baidubce.com", options)
+}
+
+func newBaiduCloudDeployer(config BaiduCloudConfig, certEndpoint, cdnEndpoint string, options Options) (*BaiduCloudDeployer, error) {
+	if config.AccessKeyId == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("baidu cloud access key id and secret access key are required")
+	}
+	client := func(endpoint string) *resty.Client {
+		return resty.New().SetBaseURL(endpoint).SetTimeout(options.RequestTimeout).
+			SetHeader("Content-Type", "application/json").
+			SetPreRequestHook(func(_ *resty.Client, r *http.Request) error {
+				baiduCloudSign(r, config.AccessKeyId, config.SecretAccessKey, time.Now())
+				return nil
+			})
+	}
+	deployer := BaiduCloudDeployer{
+		cert:       client(certEndpoint),
+		cdn:        client(cdnEndpoint),
+		options:    options,
+		updateOnly: config.UpdateOnly,
+	}
+	return &deployer, nil
+}
@@ -0,0 +1,139 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oott123/certdeploy/pkg/certparser"
+	"github.com/stretchr/testify/assert"
+)
+
+// baiduCloudMock serves certificate management and CDN APIs, checking signatures of requests
+type baiduCloudMock struct {
+	mu      sync.Mutex
+	certs   map[string]string
+	domains []baiduCloudDomain
+	https   map[string]map[string]interface{}
+	uploads int
+}
+
+func (m *baiduCloudMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	// signature itself is covered by TestBaiduCloudSign
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "bce-auth-v1/AK/"+r.Header.Get("X-Bce-Date")+"/") {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"code":"SignatureDoesNotMatch","message":"signature mismatch","requestId":"1"}`))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/certificate":
+		certs := make([]baiduCloudCertificate, 0)
+		for id, pem := range m.certs {
+			parsed, _ := certparser.CertificatesFromPEM(pem)
+			certs = append(certs, baiduCloudCertificate{CertId: id, CertStopTime: parsed[0].NotAfter.UTC().Format(time.RFC3339)})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"certs": certs})
+	case r.Method == http.MethodGet && parts[0] == "v1":
+		_ = json.NewEncoder(w).Encode(map[string]string{"certServerData": m.certs[parts[2]]})
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/certificate":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.uploads++
+		id := fmt.Sprintf("cert-new%d", m.uploads)
+		m.certs[id] = body["certServerData"]
+		_, _ = fmt.Fprintf(w, `{"certName":%q,"certId":%q}`, body["certName"], id)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/domain":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"domains": m.domains, "isTruncated": false})
+	case r.Method == http.MethodGet && len(parts) == 4:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"domain": parts[2], "https": m.https[parts[2]]})
+	case r.Method == http.MethodPut && len(parts) == 4 && r.URL.RawQuery == "https":
+		var body struct {
+			Https map[string]interface{} `json:"https"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.https[parts[2]] = body.Https
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code":"NoSuchKey","message":"not found","requestId":"2"}`))
+	}
+}
+
+func TestBaiduCloudDeployer_Deploy(t *testing.T) {
+	domains := []string{"*.example.com", "example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	mock := &baiduCloudMock{
+		certs: map[string]string{"cert-old": testDomainsCertificatePem(t, "a.example.com")},
+		domains: []baiduCloudDomain{
+			{Name: "a.example.com", Status: "RUNNING"},
+			{Name: "b.example.com", Status: "RUNNING"},
+			{Name: "c.example.com", Status: "STOPPED"},
+			{Name: "other.com", Status: "RUNNING"},
+		},
+		https: map[string]map[string]interface{}{
+			"a.example.com": {"enabled": true, "certId": "cert-old", "http2Enabled": true, "httpsRedirect": true, "httpsRedirectCode": 301.0},
+			"b.example.com": {"enabled": false},
+		},
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	d, err := newBaiduCloudDeployer(BaiduCloudConfig{AccessKeyId: "AK", SecretAccessKey: "SK", UpdateOnly: true}, server.URL, server.URL, Options{})
+	assert.NoError(t, err)
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com"}, plan.Resources())
+	assert.Contains(t, plan.Items, PlanItem{Resource: "b.example.com", Action: ActionSkippedInactive, Reason: "matches certificate but has HTTPS disabled"})
+
+	d, err = newBaiduCloudDeployer(BaiduCloudConfig{AccessKeyId: "AK", SecretAccessKey: "SK"}, server.URL, server.URL, Options{})
+	assert.NoError(t, err)
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	assert.Equal(t, 1, result.Count(ActionSkippedInactive))
+	// fields not known by certdeploy are sent back as is
+	assert.Equal(t, map[string]interface{}{
+		"enabled": true, "certId": "cert-new1", "http2Enabled": true, "httpsRedirect": true, "httpsRedirectCode": 301.0,
+	}, mock.https["a.example.com"])
+	assert.Equal(t, map[string]interface{}{"enabled": true, "certId": "cert-new1"}, mock.https["b.example.com"])
+
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionSkippedUnchanged))
+	assert.Equal(t, 1, mock.uploads)
+
+	d, err = newBaiduCloudDeployer(BaiduCloudConfig{AccessKeyId: "wrong", SecretAccessKey: "SK"}, server.URL, server.URL, Options{})
+	assert.NoError(t, err)
+	_, err = d.Plan(context.Background(), domains, cert, "KEY")
+	assert.ErrorContains(t, err, "[SignatureDoesNotMatch] signature mismatch")
+}
+
+func TestBaiduCloudSign(t *testing.T) {
+	// the example request of BCE v1 signature docs, Authorization is the one BceV1Signer of
+	// github.com/baidubce/bce-sdk-go v0.9.230 produces for it
+	req, err := http.NewRequest(http.MethodPut, "http://bj.bcebos.com/v1/test/myfolder/readme.txt?partNumber=9&uploadId=a44cc9bab11cbd156984767aad637851", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Length", "8")
+	req.Header.Set("Content-Md5", "NFzcPqhviddjRNnSOGo4rw==")
+	baiduCloudSign(req, "aabbccddeeff11223344556677889900", "eeff00112233445566778899aabbccdd", time.Date(2015, 4, 27, 8, 23, 49, 0, time.UTC))
+	assert.Equal(t, "2015-04-27T08:23:49Z", req.Header.Get("X-Bce-Date"))
+	assert.Equal(t, "bce-auth-v1/aabbccddeeff11223344556677889900/2015-04-27T08:23:49Z/1800/"+
+		"content-length;content-md5;content-type;host;x-bce-date/9c7489641656a95d22e6bfab2d8972286b2db99919b09c0ab0ddf6f73fc07dbb",
+		req.Header.Get("Authorization"))
+}
+
+func TestBaiduCloudEscape(t *testing.T) {
+	assert.Equal(t, "a-b_c.d~e", baiduCloudEscape("a-b_c.d~e"))
+	assert.Equal(t, "%2A.example.com%2F%20%E4%B8%AD", baiduCloudEscape("*.example.com/ 中"))
+}
@@ -0,0 +1,82 @@
+package deployer
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// deployEach runs deploy for every item with at most Options.Concurrency of them at once.
+// Outcomes are logged and recorded into result in order of items, no matter which one finishes first.
+// Unless Options.ContinueOnError is set, no more item is started after a failure, and the first failure is returned.
+// Items never started are recorded as ActionNotAttempted.
+func deployEach[T any](ctx context.Context, o Options, result *Result, items []T, resource func(T) string, deploy func(ctx context.Context, item T) error) error {
+	workers := o.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	type outcome struct {
+		index int
+		err   error
+	}
+	indexes := make(chan int)
+	outcomes := make(chan outcome)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				outcomes <- outcome{index: i, err: deploy(ctx, items[i])}
+			}
+		}()
+	}
+
+	var stopOnce sync.Once
+	stop := make(chan struct{})
+	go func() {
+		defer close(indexes)
+		for i := range items {
+			select {
+			case <-stop:
+				return
+			case indexes <- i:
+				log.Printf("deploying %s (%d of %d)", resource(items[i]), i+1, len(items))
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	// outcomes are held until all items before them are recorded
+	done := make([]*outcome, len(items))
+	next := 0
+	var firstErr error
+	for out := range outcomes {
+		done[out.index] = &out
+		for next < len(items) && done[next] != nil {
+			name := resource(items[next])
+			if err := done[next].err; err != nil {
+				log.Printf("failed to deploy %s: %s", name, err)
+				if o.failed(result, name, err) && firstErr == nil {
+					firstErr = err
+					stopOnce.Do(func() { close(stop) })
+				}
+			} else {
+				log.Printf("deployed %s", name)
+				result.Updated(name)
+			}
+			next++
+		}
+	}
+	for ; next < len(items); next++ {
+		result.Add(resource(items[next]), ActionNotAttempted, nil)
+	}
+	return firstErr
+}
@@ -0,0 +1,70 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployEach(t *testing.T) {
+	items := make([]int, 20)
+	for i := range items {
+		items[i] = i
+	}
+	name := func(i int) string { return fmt.Sprintf("domain%d", i) }
+
+	var running, maxRunning int32
+	result := &Result{}
+	err := deployEach(context.Background(), Options{Concurrency: 4, ContinueOnError: true}, result, items, name, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		// later items finish first
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		if i%5 == 0 {
+			return errors.New("api error")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, maxRunning, int32(4))
+	assert.Greater(t, maxRunning, int32(1))
+	assert.Len(t, result.Entries, 20)
+	for i, entry := range result.Entries {
+		assert.Equal(t, name(i), entry.Resource)
+		if i%5 == 0 {
+			assert.Equal(t, ActionFailed, entry.Action)
+		} else {
+			assert.Equal(t, ActionUpdated, entry.Action)
+		}
+	}
+
+	var started int32
+	result = &Result{}
+	err = deployEach(context.Background(), Options{Concurrency: 2}, result, items, name, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&started, 1)
+		if i == 3 {
+			return errors.New("api error")
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "api error")
+	assert.Less(t, started, int32(20))
+	// every item is listed, the ones never started as not attempted
+	assert.Len(t, result.Entries, 20)
+	assert.Equal(t, ActionFailed, result.Entries[3].Action)
+	assert.Equal(t, int(started), 20-result.Count(ActionNotAttempted))
+	for i, entry := range result.Entries {
+		assert.Equal(t, name(i), entry.Resource)
+	}
+}
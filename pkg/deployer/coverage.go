@@ -0,0 +1,36 @@
+package deployer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oott123/certdeploy/pkg/util"
+)
+
+// certificateCovers checks whether an existing certificate for existingDomains should be replaced by a certificate for certDomains.
+// All existing domains must be covered, or any of them if anyDomain is set.
+func certificateCovers(anyDomain bool, certDomains, existingDomains []string) (bool, string) {
+	covered := make([]string, 0, len(existingDomains))
+	uncovered := make([]string, 0)
+	for _, existingDomain := range existingDomains {
+		if util.MatchDomains(certDomains, existingDomain) {
+			covered = append(covered, existingDomain)
+		} else {
+			uncovered = append(uncovered, existingDomain)
+		}
+	}
+
+	if anyDomain {
+		if len(covered) == 0 {
+			return false, fmt.Sprintf("none of %s is covered", strings.Join(existingDomains, ", "))
+		}
+		return true, fmt.Sprintf("existing certificate for %s, covering %s", strings.Join(existingDomains, ", "), strings.Join(covered, ", "))
+	}
+	if len(existingDomains) == 0 {
+		return false, "existing certificate has no domains"
+	}
+	if len(uncovered) > 0 {
+		return false, fmt.Sprintf("%s not covered", strings.Join(uncovered, ", "))
+	}
+	return true, fmt.Sprintf("existing certificate for %s", strings.Join(existingDomains, ", "))
+}
@@ -1,26 +1,64 @@
 package deployer
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+
+	"github.com/oott123/certdeploy/pkg/config"
+)
 
 type Deployer interface {
 	Name() string
-	Deploy(domains []string, cert, key string) error
+	// Plan finds resources which Deploy would update using read-only APIs only
+	Plan(ctx context.Context, domains []string, cert, key string) (*Plan, error)
+	// Deploy deploys cert and key to all related resources, while domains indicate the domains contains in certificate
+	// The result lists every target resource even if an error is returned, it might be nil if discovery failed.
+	Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error)
+}
+
+// Create creates deployer by its name, configured by environment variables.
+// Names not registered are looked up as exec plugins named certdeploy-<name> in PATH.
+func Create(name string, options Options) (Deployer, error) {
+	if r, found := Lookup(name); found {
+		return r.FromEnv(options)
+	}
+	path, err := lookupPlugin(name)
+	if err != nil {
+		return nil, fmt.Errorf("create deployer failed: no deployer named %s: %w", name, err)
+	}
+	return CreateExecDeployer(ExecConfig{Name: name, Path: path}, options)
+}
+
+// CreateFromTarget creates deployer by target type, configured by target config in config file
+func CreateFromTarget(target *config.Target, options Options) (Deployer, error) {
+	if r, found := Lookup(target.Type); found {
+		return r.FromTarget(target, options)
+	}
+	path, err := lookupPlugin(target.Type)
+	if err != nil {
+		return nil, fmt.Errorf("create deployer for target %s failed: no deployer named %s: %w", target.Name, target.Type, err)
+	}
+	c := ExecConfig{Name: target.Type, Path: path}
+	err = target.Decode(&c.Config)
+	if err != nil {
+		return nil, err
+	}
+	return CreateExecDeployer(c, options)
+}
+
+func createFromTarget[TConfig any, TDeployer Deployer](target *config.Target, options Options, create func(TConfig, Options) (TDeployer, error)) (Deployer, error) {
+	var c TConfig
+	err := target.Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+	return createWith(c, options, create)
 }
 
-func Create(name string) (Deployer, error) {
-	if name == "aliyun" {
-		return CreateAliyunDeployer()
-	} else if name == "upyun" {
-		return CreateUpyunDeployer()
-	} else if name == "tencentcloud" {
-		return CreateTencentCloudDeployer()
-	} else if name == "udomain" {
-		return CreateUDomainDeployer()
-	} else if name == "azure" {
-		return CreateAzureDeployer()
-	} else if name == "volc" {
-		return CreateVolcDeployer()
-	} else {
-		return nil, fmt.Errorf("create deployer failed: no deployer named %s", name)
+func createWith[TConfig any, TDeployer Deployer](c TConfig, options Options, create func(TConfig, Options) (TDeployer, error)) (Deployer, error) {
+	d, err := create(c, options)
+	if err != nil {
+		return nil, err
 	}
+	return d, nil
 }
@@ -0,0 +1,163 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// ExecPluginPrefix is the prefix of executables in PATH which are used as deployers
+const ExecPluginPrefix = "certdeploy-"
+
+// ExecProtocolVersion is the version of the exec plugin protocol
+const ExecProtocolVersion = 1
+
+// ExecConfig configures an exec plugin, Config is passed to the plugin as is
+type ExecConfig struct {
+	Name   string
+	Path   string
+	Config map[string]interface{}
+}
+
+// ExecDeployer runs an external executable speaking the exec plugin protocol:
+// an ExecRequest is written to its stdin as JSON, and an ExecResponse is read from its stdout.
+// Stderr of the plugin is passed through as logs.
+type ExecDeployer struct {
+	name    string
+	path    string
+	config  map[string]interface{}
+	options Options
+}
+
+// ExecRequest is written to stdin of exec plugins
+type ExecRequest struct {
+	Version int `json:"version"`
+	// Action is plan or deploy, plugins must not change anything when planning
+	Action          string                 `json:"action"`
+	Domains         []string               `json:"domains"`
+	Cert            string                 `json:"cert"`
+	Key             string                 `json:"key"`
+	Config          map[string]interface{} `json:"config,omitempty"`
+	Force           bool                   `json:"force"`
+	ContinueOnError bool                   `json:"continue_on_error"`
+}
+
+// ExecResponse is read from stdout of exec plugins
+type ExecResponse struct {
+	Items []ExecResponseItem `json:"items"`
+	// Error fails the whole deployment if not empty
+	Error string `json:"error,omitempty"`
+}
+
+type ExecResponseItem struct {
+	Resource string `json:"resource"`
+	Action   Action `json:"action"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+var _ Deployer = (*ExecDeployer)(nil)
+
+func (d *ExecDeployer) Name() string {
+	return d.name
+}
+
+func (d *ExecDeployer) Plan(ctx context.Context, domains []string, cert, key string) (*Plan, error) {
+	resp, err := d.run(ctx, "plan", domains, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	for _, item := range resp.Items {
+		plan.Items = append(plan.Items, PlanItem{Resource: item.Resource, Action: item.Action, Reason: item.Reason})
+	}
+	return plan, nil
+}
+
+func (d *ExecDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	resp, err := d.run(ctx, "deploy", domains, cert, key)
+	if resp == nil {
+		return nil, err
+	}
+	result := &Result{}
+	for _, item := range resp.Items {
+		var itemErr error
+		if item.Action == ActionFailed {
+			itemErr = errors.New(item.Error)
+			if item.Error == "" {
+				itemErr = errors.New("failed without message")
+			}
+		}
+		result.Add(item.Resource, item.Action, itemErr)
+	}
+	if err != nil {
+		return result, err
+	}
+	return result, result.Err()
+}
+
+// run executes the plugin, the response is returned along with the error if it is readable
+func (d *ExecDeployer) run(ctx context.Context, action string, domains []string, cert, key string) (*ExecResponse, error) {
+	input, err := json.Marshal(ExecRequest{
+		Version:         ExecProtocolVersion,
+		Action:          action,
+		Domains:         domains,
+		Cert:            cert,
+		Key:             key,
+		Config:          d.config,
+		Force:           d.options.Force,
+		ContinueOnError: d.options.ContinueOnError,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plugin request: %w", err)
+	}
+
+	log.Printf("running plugin %s to %s", d.path, action)
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, d.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	runErr := cmd.Run()
+
+	var resp ExecResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("plugin %s failed: %w", d.name, runErr)
+		}
+		return nil, fmt.Errorf("failed to parse response of plugin %s: %w", d.name, err)
+	}
+	if resp.Error != "" {
+		return &resp, fmt.Errorf("plugin %s failed: %s", d.name, resp.Error)
+	}
+	if runErr != nil {
+		return &resp, fmt.Errorf("plugin %s failed: %w", d.name, runErr)
+	}
+	return &resp, nil
+}
+
+func lookupPlugin(name string) (string, error) {
+	return exec.LookPath(ExecPluginPrefix + name)
+}
+
+func CreateExecDeployer(config ExecConfig, options Options) (*ExecDeployer, error) {
+	if config.Path == "" {
+		path, err := lookupPlugin(config.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find plugin %s: %w", config.Name, err)
+		}
+		config.Path = path
+	}
+	deployer := ExecDeployer{
+		name:    config.Name,
+		path:    config.Path,
+		config:  config.Config,
+		options: options,
+	}
+	return &deployer, nil
+}
@@ -0,0 +1,53 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oott123/certdeploy/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePlugin records its request and answers by action
+const fakePlugin = `#!/bin/sh
+cat > "$(dirname "$0")/request.json"
+if grep -q '"action":"plan"' "$(dirname "$0")/request.json"; then
+  echo '{"items":[{"resource":"a.example.com","action":"updated","reason":"matches"}]}'
+else
+  echo 'deploying' >&2
+  echo '{"items":[{"resource":"a.example.com","action":"updated"},{"resource":"b.example.com","action":"failed","error":"denied"}]}'
+fi
+`
+
+func TestExecDeployer(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "certdeploy-fake"), []byte(fakePlugin), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	f, err := config.Parse([]byte("targets:\n  - name: private\n    type: fake\n    config:\n      endpoint: https://cdn.example.com\n"))
+	assert.NoError(t, err)
+	target, _ := f.Target("private")
+
+	d, err := CreateFromTarget(target, Options{ContinueOnError: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "fake", d.Name())
+
+	plan, err := d.Plan(context.Background(), []string{"*.example.com"}, "CERT", "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com"}, plan.Resources())
+	request, err := os.ReadFile(filepath.Join(dir, "request.json"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"version":1,"action":"plan","domains":["*.example.com"],"cert":"CERT","key":"KEY",
+		"config":{"endpoint":"https://cdn.example.com"},"force":false,"continue_on_error":true}`, string(request))
+
+	result, err := d.Deploy(context.Background(), []string{"*.example.com"}, "CERT", "KEY")
+	assert.ErrorContains(t, err, "b.example.com: denied")
+	assert.Len(t, result.Entries, 2)
+	assert.Equal(t, 1, result.Count(ActionUpdated))
+
+	d, err = Create("fake", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "fake", d.Name())
+}
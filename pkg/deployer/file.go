@@ -0,0 +1,374 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type FileConfig struct {
+	// Cert is the path of leaf certificate
+	Cert string `yaml:"cert"`
+	// Chain is the path of intermediate certificates
+	Chain string `yaml:"chain"`
+	// FullChain is the path of leaf and intermediate certificates
+	FullChain string `yaml:"fullchain"`
+	// Key is the path of private key
+	Key string `yaml:"key"`
+	// Combined is the path of full chain followed by private key, as haproxy uses
+	Combined string `yaml:"combined"`
+	// Mode of certificate files, 0644 if empty
+	Mode string `yaml:"mode"`
+	// KeyMode of key and combined files, 0600 if empty
+	KeyMode string `yaml:"key_mode"`
+	// Owner and Group of written files are names or ids, unchanged if empty
+	Owner string `yaml:"owner"`
+	Group string `yaml:"group"`
+	// Backup keeps previous files as <path>.bak
+	Backup bool `yaml:"backup"`
+	// ReloadCommand runs by sh after files are written, files are restored if it fails
+	ReloadCommand string `yaml:"reload_command"`
+}
+
+type FileDeployer struct {
+	files   []fileTarget
+	uid     int
+	gid     int
+	backup  bool
+	reload  string
+	options Options
+}
+
+// fileTarget is a file to write, content is built from the certificate being deployed
+type fileTarget struct {
+	path    string
+	mode    os.FileMode
+	content func(p filePems) []byte
+}
+
+type filePems struct {
+	leaf  []byte
+	chain []byte
+	key   []byte
+}
+
+// fileWritten remembers previous content of a written file to restore it
+type fileWritten struct {
+	target   fileTarget
+	previous []byte
+	existed  bool
+}
+
+var _ Deployer = (*FileDeployer)(nil)
+
+func init() {
+	Register(Define("file", "local files, with a reload command run afterwards", FileConfigFromEnv, CreateFileDeployer))
+}
+
+func (*FileDeployer) Name() string {
+	return "file"
+}
+
+// Plan compares files with the certificate to find files to write
+func (d *FileDeployer) Plan(_ context.Context, _ []string, cert, key string) (*Plan, error) {
+	plan, _, err := d.plan(cert, key)
+	return plan, err
+}
+
+func (d *FileDeployer) Deploy(ctx context.Context, _ []string, cert, key string) (*Result, error) {
+	plan, contents, err := d.plan(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	if len(plan.Resources()) == 0 {
+		log.Println("all files are up to date, reload skipped")
+		return result, nil
+	}
+
+	written := make([]fileWritten, 0)
+	for _, f := range d.files {
+		content, changed := contents[f.path]
+		if !changed {
+			continue
+		}
+		w, err := d.write(f, content)
+		if err != nil {
+			d.restore(written)
+			err = fmt.Errorf("failed to write %s: %w", f.path, err)
+			for _, w := range written {
+				result.Failed(w.target.path, fmt.Errorf("restored since another file failed: %w", err))
+			}
+			result.Failed(f.path, err)
+			return result, err
+		}
+		written = append(written, *w)
+	}
+
+	if d.reload != "" {
+		log.Printf("running reload command: %s", d.reload)
+		output, err := exec.CommandContext(ctx, "sh", "-c", d.reload).CombinedOutput()
+		if len(output) > 0 {
+			log.Printf("reload command output: %s", strings.TrimSpace(string(output)))
+		}
+		if err != nil {
+			d.restore(written)
+			err = fmt.Errorf("reload command failed, previous files restored: %w", err)
+			for _, w := range written {
+				result.Failed(w.target.path, err)
+			}
+			return result, err
+		}
+	}
+
+	for _, w := range written {
+		result.Updated(w.target.path)
+	}
+	if d.backup {
+		d.writeBackups(written)
+	}
+	return result, nil
+}
+
+func (d *FileDeployer) plan(cert, key string) (*Plan, map[string][]byte, error) {
+	pems, err := splitPems(cert, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan := &Plan{}
+	contents := make(map[string][]byte)
+	for _, f := range d.files {
+		content := f.content(*pems)
+		current, err := os.ReadFile(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", f.path, err)
+		}
+		if err == nil && bytes.Equal(current, content) && !d.options.Force {
+			plan.Skip(f.path, ActionSkippedUnchanged, "file content is the same")
+			continue
+		}
+		contents[f.path] = content
+		if err != nil {
+			plan.Add(f.path, "file does not exist")
+		} else {
+			plan.Add(f.path, "file content differs")
+		}
+	}
+	return plan, contents, nil
+}
+
+// write replaces file atomically by renaming a temporary file in the same directory
+func (d *FileDeployer) write(f fileTarget, content []byte) (*fileWritten, error) {
+	w := &fileWritten{target: f}
+	previous, err := os.ReadFile(f.path)
+	if err == nil {
+		w.previous, w.existed = previous, true
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	log.Printf("writing %s", f.path)
+	err = d.writeAtomic(f.path, f.mode, content)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (d *FileDeployer) writeAtomic(path string, mode os.FileMode, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil && (d.uid >= 0 || d.gid >= 0) {
+		err = tmp.Chown(d.uid, d.gid)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeBackups keeps previous files as <path>.bak, only after deployment succeeded so that a failed
+// deployment does not overwrite the backup of last good files
+func (d *FileDeployer) writeBackups(written []fileWritten) {
+	for _, w := range written {
+		if !w.existed {
+			continue
+		}
+		err := d.writeAtomic(w.target.path+".bak", w.target.mode, w.previous)
+		if err != nil {
+			log.Printf("failed to backup %s: %s", w.target.path, err)
+		}
+	}
+}
+
+func (d *FileDeployer) restore(written []fileWritten) {
+	for _, w := range written {
+		var err error
+		if w.existed {
+			log.Printf("restoring %s", w.target.path)
+			err = d.writeAtomic(w.target.path, w.target.mode, w.previous)
+		} else {
+			log.Printf("removing %s", w.target.path)
+			err = os.Remove(w.target.path)
+		}
+		if err != nil {
+			log.Printf("failed to restore %s: %s", w.target.path, err)
+		}
+	}
+}
+
+// splitPems splits certificate into leaf and intermediate certificates
+func splitPems(cert, key string) (*filePems, error) {
+	pems := &filePems{key: []byte(strings.TrimSpace(key) + "\n")}
+	rest := []byte(cert)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if pems.leaf == nil {
+			pems.leaf = pem.EncodeToMemory(block)
+		} else {
+			pems.chain = append(pems.chain, pem.EncodeToMemory(block)...)
+		}
+	}
+	if pems.leaf == nil {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return pems, nil
+}
+
+func FileConfigFromEnv() FileConfig {
+	return FileConfig{
+		Cert:          os.Getenv("FILE_CERT_PATH"),
+		Chain:         os.Getenv("FILE_CHAIN_PATH"),
+		FullChain:     os.Getenv("FILE_FULLCHAIN_PATH"),
+		Key:           os.Getenv("FILE_KEY_PATH"),
+		Combined:      os.Getenv("FILE_COMBINED_PATH"),
+		Mode:          os.Getenv("FILE_MODE"),
+		KeyMode:       os.Getenv("FILE_KEY_MODE"),
+		Owner:         os.Getenv("FILE_OWNER"),
+		Group:         os.Getenv("FILE_GROUP"),
+		Backup:        os.Getenv("FILE_BACKUP") == "true",
+		ReloadCommand: os.Getenv("FILE_RELOAD_COMMAND"),
+	}
+}
+
+func CreateFileDeployer(config FileConfig, options Options) (*FileDeployer, error) {
+	mode, err := parseFileMode(config.Mode, 0644)
+	if err != nil {
+		return nil, err
+	}
+	keyMode, err := parseFileMode(config.KeyMode, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deployer := FileDeployer{
+		uid:     -1,
+		gid:     -1,
+		backup:  config.Backup,
+		reload:  config.ReloadCommand,
+		options: options,
+	}
+	if config.Owner != "" {
+		deployer.uid, err = lookupId(config.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find owner %s: %w", config.Owner, err)
+		}
+	}
+	if config.Group != "" {
+		deployer.gid, err = lookupId(config.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find group %s: %w", config.Group, err)
+		}
+	}
+
+	deployer.files = pemFileTargets(config.Cert, config.Chain, config.FullChain, config.Key, config.Combined, mode, keyMode)
+	if len(deployer.files) == 0 {
+		return nil, fmt.Errorf("no file path given")
+	}
+	return &deployer, nil
+}
+
+// pemFileTargets lists files of given paths, empty paths are left out
+func pemFileTargets(cert, chain, fullChain, key, combined string, mode, keyMode os.FileMode) []fileTarget {
+	targets := []fileTarget{
+		{cert, mode, func(p filePems) []byte { return p.leaf }},
+		{chain, mode, func(p filePems) []byte { return p.chain }},
+		{fullChain, mode, func(p filePems) []byte { return concatBytes(p.leaf, p.chain) }},
+		{key, keyMode, func(p filePems) []byte { return p.key }},
+		{combined, keyMode, func(p filePems) []byte { return concatBytes(p.leaf, p.chain, p.key) }},
+	}
+	files := make([]fileTarget, 0, len(targets))
+	for _, t := range targets {
+		if t.path != "" {
+			files = append(files, t)
+		}
+	}
+	return files
+}
+
+func parseFileMode(value string, defaultMode os.FileMode) (os.FileMode, error) {
+	if value == "" {
+		return defaultMode, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode %s", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// lookupId returns numeric id directly, or finds id of name
+func lookupId(value string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	id, err := lookup(value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
@@ -0,0 +1,40 @@
+package deployer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCertificatePem creates a self-signed certificate for domains, valid from an hour ago to an hour later
+func newTestCertificatePem(t *testing.T, serial int64, domains ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func testCertificatePem(t *testing.T, serial int64) string {
+	return newTestCertificatePem(t, serial, "example.com")
+}
+
+// testDomainsCertificatePem creates a certificate with a unique serial, so that every call returns a new certificate
+func testDomainsCertificatePem(t *testing.T, domains ...string) string {
+	return newTestCertificatePem(t, time.Now().UnixNano(), domains...)
+}
@@ -0,0 +1,355 @@
+package deployer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// huaweiCloudBatchSize is the maximum domains of a single https config request
+const huaweiCloudBatchSize = 50
+
+type HuaweiCloudConfig struct {
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// Endpoint of CDN API, https://cdn.myhuaweicloud.com if empty
+	Endpoint string `yaml:"endpoint"`
+	// EnterpriseProjectId limits domains to an enterprise project, all projects if empty
+	EnterpriseProjectId string `yaml:"enterprise_project_id"`
+	// UpdateOnly only updates certs for CDN domains with HTTPS enabled
+	UpdateOnly bool `yaml:"update_only"`
+}
+
+type HuaweiCloudDeployer struct {
+	client              *resty.Client
+	options             Options
+	enterpriseProjectId string
+	updateOnly          bool
+}
+
+type huaweiCloudDomain struct {
+	Id           string `json:"id"`
+	DomainName   string `json:"domain_name"`
+	DomainStatus string `json:"domain_status"`
+	// HttpsStatus is 0 if HTTPS is disabled
+	HttpsStatus int `json:"https_status"`
+}
+
+type huaweiCloudError struct {
+	ErrorCode string `json:"error_code"`
+	ErrorMsg  string `json:"error_msg"`
+	Error     *struct {
+		ErrorCode string `json:"error_code"`
+		ErrorMsg  string `json:"error_msg"`
+	} `json:"error"`
+}
+
+var _ Deployer = (*HuaweiCloudDeployer)(nil)
+
+func init() {
+	Register(Define("huaweicloud", "Huawei Cloud CDN domains", HuaweiCloudConfigFromEnv, CreateHuaweiCloudDeployer))
+}
+
+func (*HuaweiCloudDeployer) Name() string {
+	return "huaweicloud"
+}
+
+// Plan finds all CDN domains matching domains contains in certificate
+func (d *HuaweiCloudDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	if len(domains) < 1 {
+		return &Plan{}, nil
+	}
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("getting huawei cloud CDN domains matching given certificates")
+	plan := &Plan{}
+	seen := make(map[string]bool)
+	for _, domain := range domains {
+		normalizedDomain := normalizeWildcardDomain(domain)
+		pageNumber := 1
+		pageSize := 1000
+		for {
+			log.Printf("domain %s, page %d ...", normalizedDomain, pageNumber)
+			query := d.projectQuery()
+			if d.enterpriseProjectId == "" {
+				query.Set("enterprise_project_id", "ALL")
+			}
+			// domain_name is a fuzzy search, checkCovered filters the results
+			query.Set("domain_name", strings.TrimPrefix(normalizedDomain, "."))
+			query.Set("page_size", strconv.Itoa(pageSize))
+			query.Set("page_number", strconv.Itoa(pageNumber))
+			var page struct {
+				Domains []huaweiCloudDomain `json:"domains"`
+				Total   int                 `json:"total"`
+			}
+			err := d.request(ctx, http.MethodGet, "/v1.0/cdn/domains", query, nil, &page)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list domains with %s: %w", normalizedDomain, err)
+			}
+			for _, cdnDomain := range page.Domains {
+				if seen[cdnDomain.DomainName] {
+					continue
+				}
+				if !checkCovered(domains, cdnDomain.DomainName, normalizedDomain) {
+					seen[cdnDomain.DomainName] = true
+					continue
+				}
+				if !d.checkDomainStatus(cdnDomain.DomainStatus) {
+					seen[cdnDomain.DomainName] = true
+					plan.Skip(cdnDomain.DomainName, ActionSkippedInactive, fmt.Sprintf("matches %s but is %s", domain, cdnDomain.DomainStatus))
+					continue
+				}
+				if d.updateOnly {
+					seen[cdnDomain.DomainName] = true
+					if cdnDomain.HttpsStatus != 0 {
+						d.planDomain(ctx, plan, info, cdnDomain.DomainName, fmt.Sprintf("matches %s and has HTTPS enabled", domain))
+					} else {
+						plan.Skip(cdnDomain.DomainName, ActionSkippedInactive, fmt.Sprintf("matches %s but has HTTPS disabled", domain))
+					}
+				} else {
+					seen[cdnDomain.DomainName] = true
+					d.planDomain(ctx, plan, info, cdnDomain.DomainName, fmt.Sprintf("matches %s", domain))
+				}
+			}
+			if page.Total > pageSize*pageNumber {
+				pageNumber++
+			} else {
+				break
+			}
+		}
+	}
+
+	log.Printf("got %d domains to deploy", len(plan.Resources()))
+	return plan, nil
+}
+
+// Deploy deploys cert and key to all related domains in batches, while domains indicate the domains contains in certificate
+func (d *HuaweiCloudDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan, err := d.Plan(ctx, domains, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+	certName := "certdeploy-" + info.fingerprint[:16]
+
+	err = batch(plan.Resources(), huaweiCloudBatchSize, func(chunk []string) error {
+		log.Printf("deploying %s", strings.Join(chunk, ", "))
+		err := d.deployCert(ctx, chunk, certName, cert, key)
+		if err != nil {
+			err = fmt.Errorf("failed to deploy cert: %w", err)
+			stop := false
+			for _, domain := range chunk {
+				stop = d.options.failed(result, domain, err)
+			}
+			if stop {
+				return err
+			}
+			return nil
+		}
+		for _, domain := range chunk {
+			result.Updated(domain)
+		}
+		return nil
+	})
+	if err != nil {
+		result.NotAttempted(plan)
+		return result, err
+	}
+	return result, result.Err()
+}
+
+// planDomain adds domain to plan, unless it already serves the certificate
+func (d *HuaweiCloudDeployer) planDomain(ctx context.Context, plan *Plan, info *certificateInfo, domain, reason string) {
+	if !d.options.Force {
+		var config struct {
+			Configs struct {
+				Https *struct {
+					HttpsStatus      string `json:"https_status"`
+					CertificateValue string `json:"certificate_value"`
+					// ExpireTime is in milliseconds
+					ExpireTime int64 `json:"expire_time"`
+				} `json:"https"`
+			} `json:"configs"`
+		}
+		err := d.request(ctx, http.MethodGet, fmt.Sprintf("/v1.1/cdn/configuration/domains/%s/configs", url.PathEscape(domain)), d.projectQuery(), nil, &config)
+		if err != nil {
+			log.Printf("failed to get https config of domain %s, assuming it changed: %s", domain, err)
+		} else if https := config.Configs.Https; https != nil && https.HttpsStatus == "on" {
+			if https.CertificateValue != "" && info.matchesPem(https.CertificateValue) ||
+				https.CertificateValue == "" && https.ExpireTime > 0 && info.matchesExpiry(time.UnixMilli(https.ExpireTime)) {
+				plan.Skip(domain, ActionSkippedUnchanged, info.unchangedReason())
+				return
+			}
+		}
+	}
+	plan.Add(domain, reason)
+}
+
+func (d *HuaweiCloudDeployer) checkDomainStatus(status string) bool {
+	return status == "online" || status == "configuring"
+}
+
+func (d *HuaweiCloudDeployer) deployCert(ctx context.Context, domains []string, certName, cert, key string) error {
+	body := map[string]interface{}{
+		"https": map[string]interface{}{
+			"domain_name":      strings.Join(domains, ","),
+			"https_switch":     1,
+			"cert_name":        certName,
+			"certificate":      cert,
+			"private_key":      key,
+			"certificate_type": 0,
+		},
+	}
+	err := d.request(ctx, http.MethodPut, "/v1.0/cdn/domains/config-https-info", d.projectQuery(), body, nil)
+	if err != nil {
+		return fmt.Errorf("failed to call update https config api: %w", err)
+	}
+	return nil
+}
+
+func (d *HuaweiCloudDeployer) projectQuery() url.Values {
+	query := url.Values{}
+	if d.enterpriseProjectId != "" {
+		query.Set("enterprise_project_id", d.enterpriseProjectId)
+	}
+	return query
+}
+
+func (d *HuaweiCloudDeployer) request(ctx context.Context, method, path string, query url.Values, body interface{}, result interface{}) error {
+	resp, err := retryHttp(method)(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+		req := d.client.R().SetContext(ctx).SetQueryParamsFromValues(query)
+		if body != nil {
+			req.SetBody(body)
+		}
+		if result != nil {
+			req.SetResult(result)
+		}
+		return checkRestyResponse(req.Execute(method, path))
+	})
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		var e huaweiCloudError
+		_ = d.client.JSONUnmarshal(resp.Body(), &e)
+		if e.Error != nil {
+			e.ErrorCode, e.ErrorMsg = e.Error.ErrorCode, e.Error.ErrorMsg
+		}
+		return fmt.Errorf("huawei cloud returns %s: [%s] %s", resp.Status(), e.ErrorCode, e.ErrorMsg)
+	}
+	return nil
+}
+
+// huaweiCloudSign signs request with AK/SK in SDK-HMAC-SHA256 algorithm
+func huaweiCloudSign(r *http.Request, accessKey, secretKey string, now time.Time) error {
+	var body []byte
+	if r.GetBody != nil {
+		reader, err := r.GetBody()
+		if err != nil {
+			return err
+		}
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+	}
+	date := now.UTC().Format("20060102T150405Z")
+	r.Header.Set("X-Sdk-Date", date)
+
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		segments[i] = huaweiCloudEscape(segment)
+	}
+	canonicalUri := strings.Join(segments, "/")
+	if !strings.HasSuffix(canonicalUri, "/") {
+		canonicalUri += "/"
+	}
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	queryParts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			queryParts = append(queryParts, huaweiCloudEscape(k)+"="+huaweiCloudEscape(v))
+		}
+	}
+	signedHeaders := "x-sdk-date"
+	canonicalHeaders := "x-sdk-date:" + date + "\n"
+	if host := r.URL.Host; host != "" {
+		signedHeaders = "host;" + signedHeaders
+		canonicalHeaders = "host:" + host + "\n" + canonicalHeaders
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		r.Method, canonicalUri, strings.Join(queryParts, "&"), canonicalHeaders, signedHeaders, hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "SDK-HMAC-SHA256\n" + date + "\n" + hex.EncodeToString(requestHash[:])
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(stringToSign))
+	r.Header.Set("Authorization", fmt.Sprintf("SDK-HMAC-SHA256 Access=%s, SignedHeaders=%s, Signature=%s",
+		accessKey, signedHeaders, hex.EncodeToString(mac.Sum(nil))))
+	return nil
+}
+
+func huaweiCloudEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func HuaweiCloudConfigFromEnv() HuaweiCloudConfig {
+	return HuaweiCloudConfig{
+		AccessKey:           os.Getenv("HUAWEICLOUD_ACCESS_KEY"),
+		SecretKey:           os.Getenv("HUAWEICLOUD_SECRET_KEY"),
+		Endpoint:            os.Getenv("HUAWEICLOUD_CDN_ENDPOINT"),
+		EnterpriseProjectId: os.Getenv("HUAWEICLOUD_ENTERPRISE_PROJECT_ID"),
+		UpdateOnly:          os.Getenv("HUAWEICLOUD_CERT_UPDATE_ONLY") == "true",
+	}
+}
+
+func CreateHuaweiCloudDeployer(config HuaweiCloudConfig, options Options) (*HuaweiCloudDeployer, error) {
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("huawei cloud access key and secret key are required")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://cdn.myhuaweicloud.com"
+	}
+	client := resty.New().SetBaseURL(strings.TrimSuffix(config.Endpoint, "/")).SetTimeout(options.RequestTimeout).
+		SetHeader("Content-Type", "application/json").
+		SetPreRequestHook(func(_ *resty.Client, r *http.Request) error {
+			return huaweiCloudSign(r, config.AccessKey, config.SecretKey, time.Now())
+		})
+
+	deployer := HuaweiCloudDeployer{
+		client:              client,
+		options:             options,
+		enterpriseProjectId: config.EnterpriseProjectId,
+		updateOnly:          config.UpdateOnly,
+	}
+	return &deployer, nil
+}
@@ -0,0 +1,164 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// huaweiCloudMock serves CDN domain list, https config and batch https config APIs
+type huaweiCloudMock struct {
+	mu      sync.Mutex
+	domains []huaweiCloudDomain
+	certs   map[string]string
+	batches [][]string
+}
+
+func (m *huaweiCloudMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SDK-HMAC-SHA256 Access=AK, SignedHeaders=host;x-sdk-date, Signature=") ||
+		r.Header.Get("X-Sdk-Date") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error_code":"APIGW.0301","error_msg":"Incorrect IAM authentication information"}`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1.0/cdn/domains":
+		found := make([]huaweiCloudDomain, 0)
+		for _, domain := range m.domains {
+			if strings.Contains(domain.DomainName, r.URL.Query().Get("domain_name")) {
+				found = append(found, domain)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"domains": found, "total": len(found)})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1.1/cdn/configuration/domains/"):
+		domain := strings.Split(r.URL.Path, "/")[5]
+		https := map[string]interface{}{"https_status": "off"}
+		if m.certs[domain] != "" {
+			https = map[string]interface{}{"https_status": "on", "certificate_value": m.certs[domain]}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"configs": map[string]interface{}{"https": https}})
+	case r.Method == http.MethodPut && r.URL.Path == "/v1.0/cdn/domains/config-https-info":
+		var body struct {
+			Https struct {
+				DomainName  string `json:"domain_name"`
+				Certificate string `json:"certificate"`
+				CertName    string `json:"cert_name"`
+			} `json:"https"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		domains := strings.Split(body.Https.DomainName, ",")
+		if strings.HasPrefix(domains[0], "fail") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"error_code":"CDN.0100","error_msg":"invalid certificate"}}`))
+			return
+		}
+		m.batches = append(m.batches, domains)
+		for _, domain := range domains {
+			m.certs[domain] = body.Https.Certificate
+		}
+		_, _ = fmt.Fprintf(w, `{"https":{"domain_name":%q,"https_switch":1}}`, body.Https.DomainName)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestHuaweiCloudDeployer_Deploy(t *testing.T) {
+	domains := []string{"*.example.com", "example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	mock := &huaweiCloudMock{
+		domains: []huaweiCloudDomain{
+			{DomainName: "a.example.com", DomainStatus: "online"},
+			{DomainName: "b.example.com", DomainStatus: "online", HttpsStatus: 2},
+			{DomainName: "c.example.com", DomainStatus: "offline"},
+			{DomainName: "x.y.example.com", DomainStatus: "online"},
+			{DomainName: "example.com", DomainStatus: "configuring", HttpsStatus: 2},
+		},
+		certs: map[string]string{
+			"b.example.com": cert,
+			"example.com":   testDomainsCertificatePem(t, "example.com"),
+		},
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	d, err := CreateHuaweiCloudDeployer(HuaweiCloudConfig{AccessKey: "AK", SecretKey: "SK", Endpoint: server.URL, UpdateOnly: true}, Options{})
+	assert.NoError(t, err)
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, plan.Resources())
+	assert.Contains(t, plan.Items, PlanItem{Resource: "a.example.com", Action: ActionSkippedInactive, Reason: "matches *.example.com but has HTTPS disabled"})
+
+	d, err = CreateHuaweiCloudDeployer(HuaweiCloudConfig{AccessKey: "AK", SecretKey: "SK", Endpoint: server.URL}, Options{})
+	assert.NoError(t, err)
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	assert.Equal(t, 1, result.Count(ActionSkippedUnchanged))
+	assert.Equal(t, 1, result.Count(ActionSkippedInactive))
+	assert.Equal(t, [][]string{{"a.example.com", "example.com"}}, mock.batches)
+	assert.Equal(t, cert, mock.certs["a.example.com"])
+
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Count(ActionSkippedUnchanged))
+	assert.Len(t, mock.batches, 1)
+}
+
+func TestHuaweiCloudDeployer_DeployFailed(t *testing.T) {
+	domains := []string{"*.example.com"}
+	mock := &huaweiCloudMock{
+		domains: []huaweiCloudDomain{{DomainName: "fail.example.com", DomainStatus: "online"}},
+		certs:   map[string]string{},
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	d, err := CreateHuaweiCloudDeployer(HuaweiCloudConfig{AccessKey: "AK", SecretKey: "SK", Endpoint: server.URL}, Options{})
+	assert.NoError(t, err)
+	result, err := d.Deploy(context.Background(), domains, testDomainsCertificatePem(t, domains...), "KEY")
+	assert.ErrorContains(t, err, "[CDN.0100] invalid certificate")
+	assert.Equal(t, 1, result.Count(ActionFailed))
+}
+
+func TestHuaweiCloudSign(t *testing.T) {
+	// vectors from TestSigner_Sign of huaweicloud-sdk-go-v3 core/auth/signer, which signs no host header
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	get, _ := http.NewRequest(http.MethodGet, "/path?limit=1", nil)
+	assert.NoError(t, huaweiCloudSign(get, "AccessKey", "SecretKey", now))
+	assert.Equal(t, "SDK-HMAC-SHA256 Access=AccessKey, SignedHeaders=x-sdk-date, "+
+		"Signature=5a2ce64c865e0e6046321c6f3d5a77ba8413eeaf355c3166c03d58d02ac79624", get.Header.Get("Authorization"))
+
+	// the sdk encodes body with json.Encoder which appends a newline
+	post, _ := http.NewRequest(http.MethodPost, "/path?key=value", strings.NewReader(`{"Name":"test","Id":1}`+"\n"))
+	post.Header.Set("Content-Type", "application/json")
+	assert.NoError(t, huaweiCloudSign(post, "AccessKey", "SecretKey", now))
+	assert.Equal(t, "SDK-HMAC-SHA256 Access=AccessKey, SignedHeaders=x-sdk-date, "+
+		"Signature=cecc2af119b18ab70b4d094c0750f3b42c02f254903179a0fc2cc72fc9db4f59", post.Header.Get("Authorization"))
+}
+
+func TestHuaweiCloudSign_Query(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://cdn.myhuaweicloud.com/v1.0/cdn/domains?page_size=10&domain_name=a%20b", nil)
+	assert.NoError(t, err)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, huaweiCloudSign(req, "AK", "SK", now))
+	assert.Equal(t, "20240102T030405Z", req.Header.Get("X-Sdk-Date"))
+
+	same, _ := http.NewRequest(http.MethodGet, "https://cdn.myhuaweicloud.com/v1.0/cdn/domains?domain_name=a%20b&page_size=10", nil)
+	assert.NoError(t, huaweiCloudSign(same, "AK", "SK", now))
+	assert.Equal(t, req.Header.Get("Authorization"), same.Header.Get("Authorization"))
+
+	other, _ := http.NewRequest(http.MethodGet, "https://cdn.myhuaweicloud.com/v1.0/cdn/domains?domain_name=a%20b&page_size=20", nil)
+	assert.NoError(t, huaweiCloudSign(other, "AK", "SK", now))
+	assert.NotEqual(t, req.Header.Get("Authorization"), other.Header.Get("Authorization"))
+}
@@ -0,0 +1,274 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/oott123/certdeploy/pkg/certparser"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type KubernetesConfig struct {
+	// Kubeconfig is the path of kubeconfig, in-cluster config is used if empty and available,
+	// otherwise KUBECONFIG or ~/.kube/config
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Context in kubeconfig, current context if empty
+	Context string `yaml:"context"`
+	// Namespaces to find secrets in, all namespaces if empty
+	Namespaces []string `yaml:"namespaces"`
+	// LabelSelector selects secrets to update regardless of their domains if given
+	LabelSelector string `yaml:"label_selector"`
+	// Match is full or any, see AzureMatchFull and AzureMatchAny, full if empty
+	Match string `yaml:"match"`
+	// SecretName is created or updated in every namespace of Namespaces if given
+	SecretName string `yaml:"secret_name"`
+}
+
+type KubernetesDeployer struct {
+	client        kubernetes.Interface
+	namespaces    []string
+	labelSelector string
+	matchAny      bool
+	secretName    string
+	options       Options
+}
+
+// kubernetesSecret is a tls secret to update, or to create if it does not exist
+type kubernetesSecret struct {
+	namespace string
+	name      string
+	create    bool
+}
+
+func (s kubernetesSecret) resource() string {
+	return s.namespace + "/" + s.name
+}
+
+var _ Deployer = (*KubernetesDeployer)(nil)
+
+func init() {
+	Register(Define("kubernetes", "kubernetes.io/tls secrets", KubernetesConfigFromEnv, CreateKubernetesDeployer))
+}
+
+func (*KubernetesDeployer) Name() string {
+	return "kubernetes"
+}
+
+// Plan finds tls secrets selected by label selector or covered by domains in certificate
+func (d *KubernetesDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	plan := &Plan{}
+	_, err := d.findSecrets(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (d *KubernetesDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan := &Plan{}
+	secrets, err := d.findSecrets(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	err = deployEach(ctx, d.options, result, secrets, kubernetesSecret.resource, func(ctx context.Context, s kubernetesSecret) error {
+		return d.deploySecret(ctx, s, cert, key)
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, result.Err()
+}
+
+func (d *KubernetesDeployer) findSecrets(ctx context.Context, domains []string, cert string, plan *Plan) ([]kubernetesSecret, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]kubernetesSecret, 0)
+	// add queues secret unless it already serves the certificate
+	add := func(s kubernetesSecret, existing *corev1.Secret, reason string) {
+		if existing != nil && !d.options.Force && info.matchesPem(string(existing.Data[corev1.TLSCertKey])) {
+			plan.Skip(s.resource(), ActionSkippedUnchanged, info.unchangedReason())
+			return
+		}
+		secrets = append(secrets, s)
+		plan.Add(s.resource(), reason)
+	}
+
+	for _, namespace := range d.namespaces {
+		list, err := retryRequest(ctx, d.options, func(ctx context.Context) (*corev1.SecretList, error) {
+			return d.client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: d.labelSelector})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets in namespace %q: %w", namespace, err)
+		}
+
+		for i := range list.Items {
+			secret := &list.Items[i]
+			s := kubernetesSecret{namespace: secret.Namespace, name: secret.Name}
+			if secret.Type != corev1.SecretTypeTLS || secret.Name == d.secretName {
+				continue
+			}
+			if d.labelSelector != "" {
+				add(s, secret, fmt.Sprintf("matches label selector %s", d.labelSelector))
+				continue
+			}
+			existingDomains, err := certparser.DomainsFromCert(string(secret.Data[corev1.TLSCertKey]))
+			if err != nil {
+				log.Printf("leaving secret %s untouched: failed to parse tls.crt: %s", s.resource(), err)
+				continue
+			}
+			matched, reason := certificateCovers(d.matchAny, domains, existingDomains)
+			if !matched {
+				log.Printf("leaving secret %s untouched: %s", s.resource(), reason)
+				continue
+			}
+			add(s, secret, reason)
+		}
+	}
+
+	if d.secretName != "" {
+		for _, namespace := range d.namespaces {
+			s := kubernetesSecret{namespace: namespace, name: d.secretName}
+			existing, err := retryRequest(ctx, d.options, func(ctx context.Context) (*corev1.Secret, error) {
+				return d.client.CoreV1().Secrets(namespace).Get(ctx, d.secretName, metav1.GetOptions{})
+			})
+			if apierrors.IsNotFound(err) {
+				s.create = true
+				add(s, nil, "secret does not exist and would be created")
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get secret %s: %w", s.resource(), err)
+			}
+			if existing.Type != corev1.SecretTypeTLS {
+				return nil, fmt.Errorf("secret %s is %s instead of %s", s.resource(), existing.Type, corev1.SecretTypeTLS)
+			}
+			add(s, existing, "named by secret_name")
+		}
+	}
+	return secrets, nil
+}
+
+func (d *KubernetesDeployer) deploySecret(ctx context.Context, s kubernetesSecret, cert, key string) error {
+	secrets := d.client.CoreV1().Secrets(s.namespace)
+	if s.create {
+		_, err := retryMutation(ctx, d.options, func(ctx context.Context) (*corev1.Secret, error) {
+			return secrets.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+				Type:       corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       []byte(cert),
+					corev1.TLSPrivateKeyKey: []byte(key),
+				},
+			}, metav1.CreateOptions{})
+		})
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create secret: %w", err)
+		}
+		// created since it was planned, it is updated instead
+		log.Printf("secret %s already exists, updating it", s.resource())
+	}
+
+	// read again to update the latest version only
+	_, err := retryRequest(ctx, d.options, func(ctx context.Context) (*corev1.Secret, error) {
+		secret, err := secrets.Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[corev1.TLSCertKey] = []byte(cert)
+		secret.Data[corev1.TLSPrivateKeyKey] = []byte(key)
+		return secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update secret: %w", err)
+	}
+	return nil
+}
+
+func KubernetesConfigFromEnv() KubernetesConfig {
+	config := KubernetesConfig{
+		Kubeconfig:    os.Getenv("KUBERNETES_KUBECONFIG"),
+		Context:       os.Getenv("KUBERNETES_CONTEXT"),
+		LabelSelector: os.Getenv("KUBERNETES_LABEL_SELECTOR"),
+		Match:         os.Getenv("KUBERNETES_CERT_MATCH"),
+		SecretName:    os.Getenv("KUBERNETES_SECRET_NAME"),
+	}
+	if namespaces := os.Getenv("KUBERNETES_NAMESPACES"); namespaces != "" {
+		config.Namespaces = strings.Split(namespaces, ",")
+	}
+	return config
+}
+
+func CreateKubernetesDeployer(config KubernetesConfig, options Options) (*KubernetesDeployer, error) {
+	restConfig, err := kubernetesRestConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubernetes config: %w", err)
+	}
+	if options.RequestTimeout > 0 {
+		restConfig.Timeout = options.RequestTimeout
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	return newKubernetesDeployer(client, config, options)
+}
+
+func newKubernetesDeployer(client kubernetes.Interface, config KubernetesConfig, options Options) (*KubernetesDeployer, error) {
+	if config.Match != "" && config.Match != string(AzureMatchFull) && config.Match != string(AzureMatchAny) {
+		return nil, fmt.Errorf("unknown kubernetes match policy %s, should be full or any", config.Match)
+	}
+	namespaces := make([]string, 0, len(config.Namespaces))
+	for _, namespace := range config.Namespaces {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		if config.SecretName != "" {
+			return nil, fmt.Errorf("kubernetes secret_name requires namespaces")
+		}
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	deployer := KubernetesDeployer{
+		client:        client,
+		namespaces:    namespaces,
+		labelSelector: config.LabelSelector,
+		matchAny:      config.Match == string(AzureMatchAny),
+		secretName:    config.SecretName,
+		options:       options,
+	}
+	return &deployer, nil
+}
+
+func kubernetesRestConfig(config KubernetesConfig) (*rest.Config, error) {
+	if config.Kubeconfig == "" && config.Context == "" {
+		if restConfig, err := rest.InClusterConfig(); err == nil {
+			return restConfig, nil
+		}
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if config.Kubeconfig != "" {
+		rules.ExplicitPath = config.Kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: config.Context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
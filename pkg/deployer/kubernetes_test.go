@@ -0,0 +1,117 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func tlsSecret(namespace, name, cert string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte(cert), corev1.TLSPrivateKeyKey: []byte("OLD")},
+	}
+}
+
+func TestKubernetesDeployer(t *testing.T) {
+	domains := []string{"example.com", "*.example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	client := fake.NewSimpleClientset(
+		tlsSecret("web", "www", testDomainsCertificatePem(t, "www.example.com"), nil),
+		tlsSecret("web", "mixed", testDomainsCertificatePem(t, "www.example.com", "other.com"), map[string]string{"certdeploy": "true"}),
+		tlsSecret("api", "deep", testDomainsCertificatePem(t, "a.b.example.com"), nil),
+		tlsSecret("api", "current", cert, nil),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "opaque"},
+			Type:       corev1.SecretTypeOpaque,
+		},
+	)
+
+	d, err := newKubernetesDeployer(client, KubernetesConfig{}, Options{})
+	assert.NoError(t, err)
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []ResultEntry{
+		{Resource: "web/www", Action: ActionUpdated},
+		{Resource: "api/current", Action: ActionSkippedUnchanged},
+	}, result.Entries)
+	secret, err := client.CoreV1().Secrets("web").Get(context.Background(), "www", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, cert, string(secret.Data[corev1.TLSCertKey]))
+	assert.Equal(t, "KEY", string(secret.Data[corev1.TLSPrivateKeyKey]))
+
+	d, err = newKubernetesDeployer(client, KubernetesConfig{Match: "any"}, Options{})
+	assert.NoError(t, err)
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"web/mixed"}, plan.Resources())
+
+	d, err = newKubernetesDeployer(client, KubernetesConfig{LabelSelector: "certdeploy=true"}, Options{})
+	assert.NoError(t, err)
+	plan, err = d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web/mixed"}, plan.Resources())
+
+	d, err = newKubernetesDeployer(client, KubernetesConfig{Namespaces: []string{"web", "new"}, SecretName: "wildcard"}, Options{})
+	assert.NoError(t, err)
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	secret, err = client.CoreV1().Secrets("new").Get(context.Background(), "wildcard", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Equal(t, cert, string(secret.Data[corev1.TLSCertKey]))
+
+	_, err = newKubernetesDeployer(client, KubernetesConfig{SecretName: "wildcard"}, Options{})
+	assert.Error(t, err)
+	_, err = newKubernetesDeployer(client, KubernetesConfig{Match: "names"}, Options{})
+	assert.Error(t, err)
+}
+
+func TestKubernetesDeployer_Create(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	domains := []string{"example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	client := fake.NewSimpleClientset()
+	creates := 0
+	client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		creates++
+		// the secret is created by someone else after planning
+		_ = client.Tracker().Add(tlsSecret("web", "wildcard", "OLD", nil))
+		return true, nil, apierrors.NewAlreadyExists(corev1.Resource("secrets"), "wildcard")
+	})
+
+	d, err := newKubernetesDeployer(client, KubernetesConfig{Namespaces: []string{"web"}, SecretName: "wildcard"}, Options{MaxAttempts: 3})
+	assert.NoError(t, err)
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []ResultEntry{{Resource: "web/wildcard", Action: ActionUpdated}}, result.Entries)
+	assert.Equal(t, 1, creates)
+	secret, err := client.CoreV1().Secrets("web").Get(context.Background(), "wildcard", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, cert, string(secret.Data[corev1.TLSCertKey]))
+
+	// a create timed out may have taken effect, it is not sent again
+	client = fake.NewSimpleClientset()
+	creates = 0
+	client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		creates++
+		return true, nil, apierrors.NewServerTimeout(corev1.Resource("secrets"), "create", 0)
+	})
+	d, err = newKubernetesDeployer(client, KubernetesConfig{Namespaces: []string{"web"}, SecretName: "wildcard"}, Options{MaxAttempts: 3})
+	assert.NoError(t, err)
+	_, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.Error(t, err)
+	assert.Equal(t, 1, creates)
+}
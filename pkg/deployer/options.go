@@ -0,0 +1,59 @@
+package deployer
+
+import (
+	"context"
+	"time"
+)
+
+// Options are settings shared by all deployers
+type Options struct {
+	// RequestTimeout limits every single API request, no limit if zero
+	RequestTimeout time.Duration
+	// MaxAttempts limits attempts of an API request failed with temporary errors, no retry if less than 2
+	MaxAttempts int
+	// Concurrency limits resources deployed at once, one by one if less than 2
+	Concurrency int
+	// Force deploys to targets even if they already serve the certificate
+	Force bool
+	// ContinueOnError tries every resource even if some of them failed, instead of stopping at the first failure
+	ContinueOnError bool
+}
+
+// failed records the failure of resource into result, and reports whether deployment should stop now
+func (o Options) failed(result *Result, resource string, err error) bool {
+	result.Failed(resource, err)
+	return !o.ContinueOnError
+}
+
+// requestContext derives a context for a single API request
+func (o Options) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.RequestTimeout)
+}
+
+// awaitContext runs f in background and returns early once ctx is done, it is used for SDKs without context support
+func awaitContext[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		value, err := f()
+		ch <- result{value: value, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case r := <-ch:
+		return r.value, r.err
+	}
+}
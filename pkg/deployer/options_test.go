@@ -0,0 +1,27 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAwaitContext(t *testing.T) {
+	value, err := awaitContext(context.Background(), func() (int, error) {
+		return 1, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	_, err = awaitContext(ctx, func() (int, error) {
+		<-block
+		return 1, nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
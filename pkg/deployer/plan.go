@@ -0,0 +1,36 @@
+package deployer
+
+// Plan lists resources which a deployer would update or skip, it is produced by read-only discovery only
+type Plan struct {
+	Items []PlanItem `json:"items"`
+}
+
+type PlanItem struct {
+	// Resource is the domain or id of the target resource
+	Resource string `json:"resource"`
+	// Action is ActionUpdated for resources to be updated, or why the resource would be skipped
+	Action Action `json:"action"`
+	// Reason explains why the action is chosen
+	Reason string `json:"reason"`
+}
+
+// Add adds resource to be updated
+func (p *Plan) Add(resource, reason string) {
+	p.Items = append(p.Items, PlanItem{Resource: resource, Action: ActionUpdated, Reason: reason})
+}
+
+// Skip adds resource which would be skipped
+func (p *Plan) Skip(resource string, action Action, reason string) {
+	p.Items = append(p.Items, PlanItem{Resource: resource, Action: action, Reason: reason})
+}
+
+// Resources returns all resources to be updated in plan
+func (p *Plan) Resources() []string {
+	resources := make([]string, 0, len(p.Items))
+	for _, item := range p.Items {
+		if item.Action == ActionUpdated {
+			resources = append(resources, item.Resource)
+		}
+	}
+	return resources
+}
@@ -0,0 +1,386 @@
+package deployer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/oott123/certdeploy/pkg/util"
+)
+
+type QiniuConfig struct {
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// UpdateOnly only updates certs for CDN domains with HTTPS enabled
+	UpdateOnly bool `yaml:"update_only"`
+	// DeleteOld deletes certificates replaced by the new one once no domain uses them
+	DeleteOld bool `yaml:"delete_old"`
+}
+
+type QiniuDeployer struct {
+	client     *resty.Client
+	options    Options
+	updateOnly bool
+	deleteOld  bool
+}
+
+type qiniuDomain struct {
+	Name           string `json:"name"`
+	Protocol       string `json:"protocol"`
+	OperatingState string `json:"operatingState"`
+}
+
+type qiniuDomainDetail struct {
+	qiniuDomain
+	Https struct {
+		CertId      string `json:"certId"`
+		ForceHttps  bool   `json:"forceHttps"`
+		Http2Enable bool   `json:"http2Enable"`
+	} `json:"https"`
+}
+
+type qiniuCertificate struct {
+	CertId     string `json:"certid"`
+	Name       string `json:"name"`
+	CommonName string `json:"common_name"`
+	// NotAfter is in unix seconds
+	NotAfter int64 `json:"not_after"`
+}
+
+type qiniuError struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+// qiniuTargets is the domains to deploy, and the uploaded certificate id if it is already uploaded
+type qiniuTargets struct {
+	certId  string
+	domains []qiniuDomainDetail
+}
+
+var _ Deployer = (*QiniuDeployer)(nil)
+
+func init() {
+	Register(Define("qiniu", "Qiniu CDN domains", QiniuConfigFromEnv, CreateQiniuDeployer))
+}
+
+func (*QiniuDeployer) Name() string {
+	return "qiniu"
+}
+
+// Plan finds all CDN domains matching domains contains in certificate
+func (d *QiniuDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	plan := &Plan{}
+	_, err := d.findTargets(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Deploy uploads the certificate unless it is already uploaded, then switches related domains to it
+func (d *QiniuDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan := &Plan{}
+	targets, err := d.findTargets(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	if len(targets.domains) == 0 {
+		log.Printf("unable to find domains suited for certificate")
+		return result, nil
+	}
+
+	certId := targets.certId
+	if certId == "" {
+		certId, err = d.uploadCertificate(ctx, cert, key)
+		if err != nil {
+			err = fmt.Errorf("failed to upload certificate: %w", err)
+			for _, domain := range targets.domains {
+				result.Failed(domain.Name, err)
+			}
+			return result, err
+		}
+	}
+
+	err = deployEach(ctx, d.options, result, targets.domains, func(domain qiniuDomainDetail) string { return domain.Name }, func(ctx context.Context, domain qiniuDomainDetail) error {
+		err := d.bindCertificate(ctx, domain, certId)
+		if err != nil {
+			return fmt.Errorf("failed to update https config of %s: %w", domain.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if result.Err() == nil && d.deleteOld {
+		d.deleteCertificates(ctx, targets.domains, certId)
+	}
+	return result, result.Err()
+}
+
+func (d *QiniuDeployer) findTargets(ctx context.Context, domains []string, cert string, plan *Plan) (*qiniuTargets, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+	certId, err := d.findCertificate(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	targets := &qiniuTargets{certId: certId}
+
+	log.Println("getting qiniu CDN domains matching given certificates")
+	cdnDomains, err := d.listDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, cdnDomain := range cdnDomains {
+		if !util.MatchDomains(domains, cdnDomain.Name) {
+			continue
+		}
+		if cdnDomain.OperatingState != "success" {
+			plan.Skip(cdnDomain.Name, ActionSkippedInactive, fmt.Sprintf("matches certificate but is %s", cdnDomain.OperatingState))
+			continue
+		}
+		if d.updateOnly && cdnDomain.Protocol != "https" {
+			plan.Skip(cdnDomain.Name, ActionSkippedInactive, "matches certificate but has HTTPS disabled")
+			continue
+		}
+		detail, err := d.getDomain(ctx, cdnDomain.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !d.options.Force && certId != "" && detail.Https.CertId == certId {
+			plan.Skip(cdnDomain.Name, ActionSkippedUnchanged, info.unchangedReason())
+			continue
+		}
+		targets.domains = append(targets.domains, *detail)
+		if cdnDomain.Protocol == "https" {
+			plan.Add(cdnDomain.Name, "matches certificate and has HTTPS enabled")
+		} else {
+			plan.Add(cdnDomain.Name, "matches certificate")
+		}
+	}
+	log.Printf("got %d domains to deploy", len(targets.domains))
+	return targets, nil
+}
+
+// findCertificate finds an uploaded certificate with the same content, so that it is not uploaded again
+func (d *QiniuDeployer) findCertificate(ctx context.Context, info *certificateInfo) (string, error) {
+	marker := ""
+	for {
+		var page struct {
+			Marker string             `json:"marker"`
+			Certs  []qiniuCertificate `json:"certs"`
+		}
+		err := d.request(ctx, http.MethodGet, "/sslcert", url.Values{"marker": {marker}, "limit": {"100"}}, nil, &page)
+		if err != nil {
+			return "", fmt.Errorf("failed to list certificates: %w", err)
+		}
+		for _, c := range page.Certs {
+			if c.NotAfter == 0 || !info.matchesExpiry(time.Unix(c.NotAfter, 0)) {
+				continue
+			}
+			var detail struct {
+				Cert struct {
+					Ca string `json:"ca"`
+				} `json:"cert"`
+			}
+			err := d.request(ctx, http.MethodGet, "/sslcert/"+url.PathEscape(c.CertId), nil, nil, &detail)
+			if err != nil {
+				return "", fmt.Errorf("failed to get certificate %s: %w", c.CertId, err)
+			}
+			if info.matchesPem(detail.Cert.Ca) {
+				log.Printf("found uploaded certificate %s", c.CertId)
+				return c.CertId, nil
+			}
+		}
+		if page.Marker == "" || len(page.Certs) == 0 {
+			return "", nil
+		}
+		marker = page.Marker
+	}
+}
+
+func (d *QiniuDeployer) listDomains(ctx context.Context) ([]qiniuDomain, error) {
+	found := make([]qiniuDomain, 0)
+	marker := ""
+	for {
+		var page struct {
+			Marker  string        `json:"marker"`
+			Domains []qiniuDomain `json:"domains"`
+		}
+		err := d.request(ctx, http.MethodGet, "/domain", url.Values{"marker": {marker}, "limit": {"1000"}}, nil, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list domains: %w", err)
+		}
+		found = append(found, page.Domains...)
+		if page.Marker == "" || len(page.Domains) == 0 {
+			return found, nil
+		}
+		marker = page.Marker
+	}
+}
+
+func (d *QiniuDeployer) getDomain(ctx context.Context, name string) (*qiniuDomainDetail, error) {
+	var detail qiniuDomainDetail
+	err := d.request(ctx, http.MethodGet, "/domain/"+url.PathEscape(name), nil, nil, &detail)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain %s: %w", name, err)
+	}
+	return &detail, nil
+}
+
+func (d *QiniuDeployer) uploadCertificate(ctx context.Context, cert, key string) (string, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return "", err
+	}
+	commonName := info.primaryName()
+	var uploaded struct {
+		CertId string `json:"certID"`
+	}
+	err = d.request(ctx, http.MethodPost, "/sslcert", nil, map[string]string{
+		"name":        fmt.Sprintf("%s(%s)", commonName, time.Now().UTC().Format("2006-01-02")),
+		"common_name": commonName,
+		"ca":          cert,
+		"pri":         key,
+	}, &uploaded)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("uploaded cert id %s", uploaded.CertId)
+	return uploaded.CertId, nil
+}
+
+// bindCertificate modifies https config of domain, or enables https for http domains
+func (d *QiniuDeployer) bindCertificate(ctx context.Context, domain qiniuDomainDetail, certId string) error {
+	if domain.Protocol == "https" {
+		return d.request(ctx, http.MethodPut, "/domain/"+url.PathEscape(domain.Name)+"/httpsconf", nil, map[string]interface{}{
+			"certId":      certId,
+			"forceHttps":  domain.Https.ForceHttps,
+			"http2Enable": domain.Https.Http2Enable,
+		}, nil)
+	}
+	return d.request(ctx, http.MethodPut, "/domain/"+url.PathEscape(domain.Name)+"/sslize", nil, map[string]interface{}{
+		"certid":      certId,
+		"forceHttps":  false,
+		"http2Enable": false,
+	}, nil)
+}
+
+// deleteCertificates deletes certificates replaced by certId, unless some other domain still uses them
+func (d *QiniuDeployer) deleteCertificates(ctx context.Context, replaced []qiniuDomainDetail, certId string) {
+	old := make(map[string]bool)
+	for _, domain := range replaced {
+		if domain.Https.CertId != "" && domain.Https.CertId != certId {
+			old[domain.Https.CertId] = true
+		}
+	}
+	if len(old) == 0 {
+		return
+	}
+	cdnDomains, err := d.listDomains(ctx)
+	if err != nil {
+		log.Printf("keeping old certificates: %s", err)
+		return
+	}
+	for _, cdnDomain := range cdnDomains {
+		if cdnDomain.Protocol != "https" {
+			continue
+		}
+		detail, err := d.getDomain(ctx, cdnDomain.Name)
+		if err != nil {
+			log.Printf("keeping old certificates: %s", err)
+			return
+		}
+		if old[detail.Https.CertId] {
+			log.Printf("keeping certificate %s used by %s", detail.Https.CertId, cdnDomain.Name)
+			delete(old, detail.Https.CertId)
+		}
+	}
+	for id := range old {
+		err := d.request(ctx, http.MethodDelete, "/sslcert/"+url.PathEscape(id), nil, nil, nil)
+		if err != nil {
+			log.Printf("failed to delete old certificate %s: %s", id, err)
+			continue
+		}
+		log.Printf("deleted old certificate %s", id)
+	}
+}
+
+func (d *QiniuDeployer) request(ctx context.Context, method, path string, query url.Values, body interface{}, result interface{}) error {
+	resp, err := retryHttp(method)(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+		req := d.client.R().SetContext(ctx).SetQueryParamsFromValues(query)
+		if body != nil {
+			req.SetBody(body)
+		}
+		if result != nil {
+			req.SetResult(result)
+		}
+		return checkRestyResponse(req.Execute(method, path))
+	})
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		var e qiniuError
+		_ = d.client.JSONUnmarshal(resp.Body(), &e)
+		return fmt.Errorf("qiniu returns %s: %s", resp.Status(), e.Error)
+	}
+	return nil
+}
+
+// qiniuSign signs request with QBox token, body is not signed since it is always json
+func qiniuSign(r *http.Request, accessKey, secretKey string) {
+	data := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		data += "?" + r.URL.RawQuery
+	}
+	data += "\n"
+	mac := hmac.New(sha1.New, []byte(secretKey))
+	mac.Write([]byte(data))
+	r.Header.Set("Authorization", "QBox "+accessKey+":"+base64.URLEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func QiniuConfigFromEnv() QiniuConfig {
+	return QiniuConfig{
+		AccessKey:  os.Getenv("QINIU_ACCESS_KEY"),
+		SecretKey:  os.Getenv("QINIU_SECRET_KEY"),
+		UpdateOnly: os.Getenv("QINIU_CERT_UPDATE_ONLY") == "true",
+		DeleteOld:  os.Getenv("QINIU_DELETE_OLD_CERT") == "true",
+	}
+}
+
+func CreateQiniuDeployer(config QiniuConfig, options Options) (*QiniuDeployer, error) {
+	return newQiniuDeployer(config, "https://api.qiniu.com", options)
+}
+
+func newQiniuDeployer(config QiniuConfig, endpoint string, options Options) (*QiniuDeployer, error) {
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("qiniu access key and secret key are required")
+	}
+	client := resty.New().SetBaseURL(strings.TrimSuffix(endpoint, "/")).SetTimeout(options.RequestTimeout).
+		SetHeader("Content-Type", "application/json").
+		SetPreRequestHook(func(_ *resty.Client, r *http.Request) error {
+			qiniuSign(r, config.AccessKey, config.SecretKey)
+			return nil
+		})
+	deployer := QiniuDeployer{
+		client:     client,
+		options:    options,
+		updateOnly: config.UpdateOnly,
+		deleteOld:  config.DeleteOld,
+	}
+	return &deployer, nil
+}
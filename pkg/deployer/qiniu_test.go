@@ -0,0 +1,141 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oott123/certdeploy/pkg/certparser"
+	"github.com/stretchr/testify/assert"
+)
+
+// qiniuMock serves certificate and domain APIs of qiniu
+type qiniuMock struct {
+	mu      sync.Mutex
+	certs   map[string]string
+	domains []*qiniuDomainDetail
+	uploads int
+	deleted []string
+}
+
+func (m *qiniuMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "QBox AK:") {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"code":401,"error":"bad token"}`))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/sslcert":
+		certs := make([]qiniuCertificate, 0)
+		for id, pem := range m.certs {
+			parsed, _ := certparser.CertificatesFromPEM(pem)
+			certs = append(certs, qiniuCertificate{CertId: id, NotAfter: parsed[0].NotAfter.Unix()})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"marker": "", "certs": certs})
+	case r.Method == http.MethodGet && parts[0] == "sslcert":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"cert": map[string]string{"certid": parts[1], "ca": m.certs[parts[1]]}})
+	case r.Method == http.MethodPost && r.URL.Path == "/sslcert":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.uploads++
+		id := fmt.Sprintf("new-%d", m.uploads)
+		m.certs[id] = body["ca"]
+		_, _ = fmt.Fprintf(w, `{"certID":%q}`, id)
+	case r.Method == http.MethodDelete && parts[0] == "sslcert":
+		m.deleted = append(m.deleted, parts[1])
+		delete(m.certs, parts[1])
+		_, _ = w.Write([]byte(`{}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/domain":
+		domains := make([]qiniuDomain, 0)
+		for _, domain := range m.domains {
+			domains = append(domains, domain.qiniuDomain)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"marker": "", "domains": domains})
+	case parts[0] == "domain":
+		for _, domain := range m.domains {
+			if domain.Name != parts[1] {
+				continue
+			}
+			if r.Method == http.MethodGet {
+				_ = json.NewEncoder(w).Encode(domain)
+				return
+			}
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if parts[2] == "sslize" {
+				domain.Protocol = "https"
+				domain.Https.CertId = body["certid"].(string)
+			} else {
+				domain.Https.CertId = body["certId"].(string)
+			}
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code":404,"error":"no such domain"}`))
+	}
+}
+
+func qiniuTestDomain(name, protocol, state, certId string) *qiniuDomainDetail {
+	domain := &qiniuDomainDetail{qiniuDomain: qiniuDomain{Name: name, Protocol: protocol, OperatingState: state}}
+	domain.Https.CertId = certId
+	return domain
+}
+
+func TestQiniuDeployer_Deploy(t *testing.T) {
+	domains := []string{"*.example.com", "example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	mock := &qiniuMock{
+		certs: map[string]string{
+			"old-1": testDomainsCertificatePem(t, "a.example.com", "z.other.com"),
+			"old-2": testDomainsCertificatePem(t, "b.example.com"),
+		},
+		domains: []*qiniuDomainDetail{
+			qiniuTestDomain("a.example.com", "https", "success", "old-1"),
+			qiniuTestDomain("b.example.com", "https", "success", "old-2"),
+			qiniuTestDomain("c.example.com", "http", "success", ""),
+			qiniuTestDomain("d.example.com", "https", "processing", "old-2"),
+			qiniuTestDomain("z.other.com", "https", "success", "old-1"),
+		},
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	d, err := newQiniuDeployer(QiniuConfig{AccessKey: "AK", SecretKey: "SK", UpdateOnly: true}, server.URL, Options{})
+	assert.NoError(t, err)
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, plan.Resources())
+	assert.Contains(t, plan.Items, PlanItem{Resource: "c.example.com", Action: ActionSkippedInactive, Reason: "matches certificate but has HTTPS disabled"})
+
+	d, err = newQiniuDeployer(QiniuConfig{AccessKey: "AK", SecretKey: "SK", DeleteOld: true}, server.URL, Options{})
+	assert.NoError(t, err)
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Count(ActionUpdated))
+	assert.Equal(t, 1, result.Count(ActionSkippedInactive))
+	for _, domain := range mock.domains[:3] {
+		assert.Equal(t, "new-1", domain.Https.CertId)
+	}
+	assert.Equal(t, "https", mock.domains[2].Protocol)
+	// old-1 is still used by z.other.com, old-2 by d.example.com
+	assert.Empty(t, mock.deleted)
+
+	mock.domains = mock.domains[:3]
+	mock.domains[0].Https.CertId = "old-2"
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Count(ActionUpdated))
+	assert.Equal(t, 2, result.Count(ActionSkippedUnchanged))
+	assert.Equal(t, 1, mock.uploads)
+	assert.Equal(t, []string{"old-2"}, mock.deleted)
+}
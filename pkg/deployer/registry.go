@@ -0,0 +1,114 @@
+package deployer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oott123/certdeploy/pkg/config"
+)
+
+// Registration describes a deployer which can be created by its name
+type Registration struct {
+	Name        string
+	Description string
+	// Schema lists fields of the target config in config file
+	Schema []ConfigField
+	// FromEnv creates the deployer configured by environment variables
+	FromEnv func(options Options) (Deployer, error)
+	// FromTarget creates the deployer configured by a target in config file
+	FromTarget func(target *config.Target, options Options) (Deployer, error)
+}
+
+// ConfigField is a field of target config
+type ConfigField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Registration)
+)
+
+// Register makes a deployer available by its name, it panics if the name is registered twice
+func Register(r Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if r.Name == "" || r.FromEnv == nil || r.FromTarget == nil {
+		panic("deployer: registration requires name and factories")
+	}
+	if _, found := registry[r.Name]; found {
+		panic("deployer: register called twice for " + r.Name)
+	}
+	registry[r.Name] = r
+}
+
+// Define builds a registration from a typed config loader and constructor
+func Define[TConfig any, TDeployer Deployer](name, description string, fromEnv func() TConfig, create func(TConfig, Options) (TDeployer, error)) Registration {
+	return Registration{
+		Name:        name,
+		Description: description,
+		Schema:      schemaOf(reflect.TypeOf((*TConfig)(nil)).Elem()),
+		FromEnv: func(options Options) (Deployer, error) {
+			return createWith(fromEnv(), options, create)
+		},
+		FromTarget: func(target *config.Target, options Options) (Deployer, error) {
+			return createFromTarget(target, options, create)
+		},
+	}
+}
+
+// Lookup finds a registered deployer by its name
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, found := registry[name]
+	return r, found
+}
+
+// Registrations lists all registered deployers sorted by name
+func Registrations() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := make([]Registration, 0, len(registry))
+	for _, r := range registry {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func schemaOf(t reflect.Type) []ConfigField {
+	fields := make([]ConfigField, 0)
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields = append(fields, ConfigField{Name: name, Type: typeName(f.Type)})
+	}
+	return fields
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Slice:
+		return "[]" + typeName(t.Elem())
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", typeName(t.Key()), typeName(t.Elem()))
+	case reflect.Pointer:
+		return typeName(t.Elem())
+	default:
+		return t.Kind().String()
+	}
+}
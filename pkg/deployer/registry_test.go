@@ -0,0 +1,34 @@
+package deployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	names := make([]string, 0)
+	for _, r := range Registrations() {
+		names = append(names, r.Name)
+		assert.NotEmpty(t, r.Description)
+	}
+	for i := 1; i < len(names); i++ {
+		assert.Less(t, names[i-1], names[i], "registrations should be sorted and unique")
+	}
+	assert.Subset(t, names, []string{"aliyun", "azure", "file", "tencentcloud", "volc", "webhook"})
+
+	r, found := Lookup("aliyun")
+	assert.True(t, found)
+	assert.Contains(t, r.Schema, ConfigField{Name: "access_key_id", Type: "string"})
+	assert.Contains(t, r.Schema, ConfigField{Name: "update_only", Type: "bool"})
+
+	r, _ = Lookup("volc")
+	assert.Contains(t, r.Schema, ConfigField{Name: "deploy_targets", Type: "[]string"})
+
+	assert.Panics(t, func() {
+		Register(Define("aliyun", "duplicated", AliyunConfigFromEnv, CreateAliyunDeployer))
+	})
+
+	_, err := Create("not-exist", Options{})
+	assert.ErrorContains(t, err, "no deployer named not-exist")
+}
@@ -0,0 +1,102 @@
+package deployer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Action is what happened, or what would happen in a plan, to a target resource
+type Action string
+
+const (
+	ActionUpdated          Action = "updated"
+	ActionSkippedUnchanged Action = "skipped-unchanged"
+	ActionSkippedInactive  Action = "skipped-inactive"
+	ActionFailed           Action = "failed"
+	// ActionNotAttempted is for resources left untouched since deployment stopped at an earlier failure
+	ActionNotAttempted Action = "not-attempted"
+)
+
+// Result lists every target resource of a deployment and the action taken on it
+type Result struct {
+	Entries []ResultEntry `json:"entries"`
+}
+
+type ResultEntry struct {
+	// Resource is the domain or id of the target resource
+	Resource string
+	Action   Action
+	// Err is the reason of failure if Action is ActionFailed
+	Err error
+}
+
+// NewResult creates a result containing resources skipped by plan
+func NewResult(plan *Plan) *Result {
+	result := &Result{Entries: make([]ResultEntry, 0)}
+	for _, item := range plan.Items {
+		if item.Action != ActionUpdated {
+			result.Add(item.Resource, item.Action, nil)
+		}
+	}
+	return result
+}
+
+func (r *Result) Add(resource string, action Action, err error) {
+	r.Entries = append(r.Entries, ResultEntry{Resource: resource, Action: action, Err: err})
+}
+
+func (r *Result) Updated(resource string) {
+	r.Add(resource, ActionUpdated, nil)
+}
+
+func (r *Result) Failed(resource string, err error) {
+	r.Add(resource, ActionFailed, err)
+}
+
+// NotAttempted records resources to be updated in plan which have no outcome yet, after deployment stopped early
+func (r *Result) NotAttempted(plan *Plan) {
+	recorded := make(map[string]bool, len(r.Entries))
+	for _, entry := range r.Entries {
+		recorded[entry.Resource] = true
+	}
+	for _, resource := range plan.Resources() {
+		if !recorded[resource] {
+			r.Add(resource, ActionNotAttempted, nil)
+		}
+	}
+}
+
+// Count counts entries with given action
+func (r *Result) Count(action Action) int {
+	count := 0
+	for _, entry := range r.Entries {
+		if entry.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// Err aggregates errors of all failed entries, it is nil if nothing failed
+func (r *Result) Err() error {
+	errs := make([]error, 0)
+	for _, entry := range r.Entries {
+		if entry.Action == ActionFailed {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Resource, entry.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e ResultEntry) MarshalJSON() ([]byte, error) {
+	entry := struct {
+		Resource string `json:"resource"`
+		Action   Action `json:"action"`
+		Error    string `json:"error,omitempty"`
+	}{Resource: e.Resource, Action: e.Action}
+	if e.Err != nil {
+		entry.Error = e.Err.Error()
+	}
+	return json.Marshal(entry)
+}
@@ -0,0 +1,49 @@
+package deployer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewResult(t *testing.T) {
+	plan := &Plan{}
+	plan.Add("a.example.com", "matches *.example.com")
+	plan.Skip("b.example.com", ActionSkippedInactive, "matches *.example.com but is offline")
+
+	result := NewResult(plan)
+	assert.Equal(t, []ResultEntry{{Resource: "b.example.com", Action: ActionSkippedInactive}}, result.Entries)
+	assert.Equal(t, []string{"a.example.com"}, plan.Resources())
+}
+
+func TestResultErr(t *testing.T) {
+	result := &Result{}
+	result.Updated("a.example.com")
+	assert.NoError(t, result.Err())
+
+	errB := errors.New("b failed")
+	errC := errors.New("c failed")
+	assert.True(t, Options{}.failed(result, "b.example.com", errB))
+	assert.False(t, Options{ContinueOnError: true}.failed(result, "c.example.com", errC))
+
+	err := result.Err()
+	assert.ErrorIs(t, err, errB)
+	assert.ErrorIs(t, err, errC)
+	assert.Equal(t, 2, result.Count(ActionFailed))
+	assert.Equal(t, 1, result.Count(ActionUpdated))
+}
+
+func TestResultNotAttempted(t *testing.T) {
+	plan := &Plan{}
+	plan.Add("a.example.com", "matches *.example.com")
+	plan.Add("b.example.com", "matches *.example.com")
+	plan.Skip("c.example.com", ActionSkippedInactive, "matches *.example.com but is offline")
+
+	result := NewResult(plan)
+	result.Failed("a.example.com", errors.New("a failed"))
+	result.NotAttempted(plan)
+	assert.Equal(t, 1, result.Count(ActionNotAttempted))
+	assert.Equal(t, ResultEntry{Resource: "b.example.com", Action: ActionNotAttempted}, result.Entries[2])
+	assert.EqualError(t, result.Err(), "a.example.com: a failed")
+}
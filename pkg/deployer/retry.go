@@ -0,0 +1,255 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/alibabacloud-go/tea/tea"
+	awsretry "github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/go-resty/resty/v2"
+	tcerr "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var (
+	// retryBaseDelay is the delay before the first retry, doubled for every following one
+	retryBaseDelay = time.Second
+	// retryMaxDelay caps the delay between retries, including ones asked by Retry-After
+	retryMaxDelay = time.Minute
+)
+
+// retryableError marks err as worth retrying, after is the delay asked by server if not zero.
+// unprocessed tells the request was rejected before being processed, such as throttling, so that even mutations are retried
+type retryableError struct {
+	err         error
+	after       time.Duration
+	unprocessed bool
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// retryRequest calls f with a request context until it succeeds, fails with a non-retryable error,
+// or Options.MaxAttempts is reached. f must be safe to repeat, such as reading or setting something.
+func retryRequest[T any](ctx context.Context, o Options, f func(ctx context.Context) (T, error)) (T, error) {
+	return retry(ctx, o, classifyError, f)
+}
+
+// retryMutation calls f like retryRequest, but f creates something which must not be repeated once the request
+// may have been processed, like uploading a certificate, so only throttling and connection failures are retried
+func retryMutation[T any](ctx context.Context, o Options, f func(ctx context.Context) (T, error)) (T, error) {
+	return retry(ctx, o, classifyUnprocessed, f)
+}
+
+func retry[T any](ctx context.Context, o Options, classify func(err error) (bool, time.Duration), f func(ctx context.Context) (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		reqCtx, cancel := o.requestContext(ctx)
+		value, err := f(reqCtx)
+		cancel()
+		if err == nil || ctx.Err() != nil || attempt >= o.MaxAttempts {
+			return value, err
+		}
+		retryable, after := classify(err)
+		if !retryable {
+			return value, err
+		}
+
+		delay := retryDelay(attempt, after)
+		log.Printf("retrying in %s (attempt %d of %d failed): %s", delay.Round(time.Millisecond), attempt, o.MaxAttempts, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return value, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay is the jittered exponential delay before the retry following attempt
+func retryDelay(attempt int, after time.Duration) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 30 && retryBaseDelay<<(attempt-1) < retryMaxDelay {
+		delay = retryBaseDelay << (attempt - 1)
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if after > delay {
+		delay = after
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// classifyError reports whether err is temporary, such as throttling or server errors of vendor APIs,
+// and the delay server asked for
+func classifyError(err error) (bool, time.Duration) {
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return true, retryable.after
+	}
+
+	var aliyunErr *tea.SDKError
+	if errors.As(err, &aliyunErr) {
+		code := tea.StringValue(aliyunErr.Code)
+		return strings.HasPrefix(code, "Throttling") || code == "ServiceUnavailable" ||
+			retryableStatus(tea.IntValue(aliyunErr.StatusCode)), 0
+	}
+
+	var tencentErr *tcerr.TencentCloudSDKError
+	if errors.As(err, &tencentErr) {
+		code := tencentErr.GetCode()
+		return strings.HasPrefix(code, "RequestLimitExceeded") || code == "InternalError" ||
+			code == "ClientError.NetworkError", 0
+	}
+
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		var after time.Duration
+		if azureErr.RawResponse != nil {
+			after = parseRetryAfter(azureErr.RawResponse.Header.Get("Retry-After"))
+		}
+		return retryableStatus(azureErr.StatusCode), after
+	}
+
+	var awsErr *awshttp.ResponseError
+	if errors.As(err, &awsErr) {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if _, throttled := awsretry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]; throttled {
+				return true, 0
+			}
+		}
+		return retryableStatus(awsErr.HTTPStatusCode()), 0
+	}
+
+	var kubernetesErr apierrors.APIStatus
+	if errors.As(err, &kubernetesErr) {
+		after, _ := apierrors.SuggestsClientDelay(err)
+		return apierrors.IsTooManyRequests(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+			apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) || apierrors.IsConflict(err), time.Duration(after) * time.Second
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		// the request timed out while the whole deployment did not
+		return true, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout(), 0
+	}
+	return false, 0
+}
+
+// classifyUnprocessed reports whether err shows the request was never processed by the vendor API,
+// a timeout or server error is not one of them since the request may have taken effect
+func classifyUnprocessed(err error) (bool, time.Duration) {
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return retryable.unprocessed, retryable.after
+	}
+
+	var aliyunErr *tea.SDKError
+	if errors.As(err, &aliyunErr) {
+		return strings.HasPrefix(tea.StringValue(aliyunErr.Code), "Throttling") ||
+			tea.IntValue(aliyunErr.StatusCode) == http.StatusTooManyRequests, 0
+	}
+
+	var tencentErr *tcerr.TencentCloudSDKError
+	if errors.As(err, &tencentErr) {
+		return strings.HasPrefix(tencentErr.GetCode(), "RequestLimitExceeded"), 0
+	}
+
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		var after time.Duration
+		if azureErr.RawResponse != nil {
+			after = parseRetryAfter(azureErr.RawResponse.Header.Get("Retry-After"))
+		}
+		return azureErr.StatusCode == http.StatusTooManyRequests, after
+	}
+
+	var awsErr *awshttp.ResponseError
+	if errors.As(err, &awsErr) {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if _, throttled := awsretry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]; throttled {
+				return true, 0
+			}
+		}
+		return awsErr.HTTPStatusCode() == http.StatusTooManyRequests, 0
+	}
+
+	var kubernetesErr apierrors.APIStatus
+	if errors.As(err, &kubernetesErr) {
+		after, _ := apierrors.SuggestsClientDelay(err)
+		return apierrors.IsTooManyRequests(err), time.Duration(after) * time.Second
+	}
+
+	return isConnectError(err), 0
+}
+
+// isConnectError reports whether err happened before connecting to the server
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// parseRetryAfter parses Retry-After header in seconds or http date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// retryHttp chooses retryMutation for POST and PATCH requests which are not idempotent, otherwise retryRequest
+func retryHttp(method string) func(ctx context.Context, o Options, f func(ctx context.Context) (*resty.Response, error)) (*resty.Response, error) {
+	if method == http.MethodPost || method == http.MethodPatch {
+		return retryMutation[*resty.Response]
+	}
+	return retryRequest[*resty.Response]
+}
+
+// checkRestyResponse turns throttling and server errors of resty responses into retryable errors
+func checkRestyResponse(resp *resty.Response, err error) (*resty.Response, error) {
+	if err != nil || resp == nil || !retryableStatus(resp.StatusCode()) {
+		return resp, err
+	}
+	return resp, &retryableError{
+		err:         fmt.Errorf("http status %s: %s", resp.Status(), resp.String()),
+		after:       parseRetryAfter(resp.Header().Get("Retry-After")),
+		unprocessed: resp.StatusCode() == http.StatusTooManyRequests,
+	}
+}
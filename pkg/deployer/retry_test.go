@@ -0,0 +1,133 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	tcerr "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("bad request"), false},
+		{&tea.SDKError{Code: tea.String("Throttling.User"), StatusCode: tea.Int(400)}, true},
+		{&tea.SDKError{Code: tea.String("InvalidDomain.NotFound"), StatusCode: tea.Int(404)}, false},
+		{&tea.SDKError{Code: tea.String("UnknownError"), StatusCode: tea.Int(503)}, true},
+		{tcerr.NewTencentCloudSDKError("RequestLimitExceeded", "too many", ""), true},
+		{tcerr.NewTencentCloudSDKError("AuthFailure.SignatureFailure", "bad signature", ""), false},
+		{&retryableError{err: errors.New("http status 502")}, true},
+		{context.DeadlineExceeded, true},
+	}
+	for _, c := range cases {
+		retryable, _ := classifyError(c.err)
+		assert.Equal(t, c.retryable, retryable, "%s", c.err)
+	}
+}
+
+func TestRetryRequest(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	calls := 0
+	value, err := retryRequest(context.Background(), Options{MaxAttempts: 3}, func(ctx context.Context) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, &tea.SDKError{Code: tea.String("Throttling.User")}
+		}
+		return calls, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, value)
+
+	calls = 0
+	_, err = retryRequest(context.Background(), Options{MaxAttempts: 3}, func(ctx context.Context) (int, error) {
+		calls++
+		return 0, errors.New("bad request")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	_, err = retryRequest(context.Background(), Options{MaxAttempts: 2}, func(ctx context.Context) (int, error) {
+		calls++
+		return 0, &tea.SDKError{Code: tea.String("Throttling.User")}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryMutation(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	cases := []struct {
+		err   error
+		calls int
+	}{
+		{&tea.SDKError{Code: tea.String("Throttling.User"), StatusCode: tea.Int(400)}, 3},
+		{&retryableError{err: errors.New("http status 429"), unprocessed: true}, 3},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, 3},
+		// the request may have been processed
+		{&tea.SDKError{Code: tea.String("UnknownError"), StatusCode: tea.Int(503)}, 1},
+		{&retryableError{err: errors.New("http status 502")}, 1},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, 1},
+		{context.DeadlineExceeded, 1},
+	}
+	for _, c := range cases {
+		calls := 0
+		_, err := retryMutation(context.Background(), Options{MaxAttempts: 3}, func(ctx context.Context) (int, error) {
+			calls++
+			return 0, c.err
+		})
+		assert.Error(t, err)
+		assert.Equal(t, c.calls, calls, "%s", c.err)
+	}
+}
+
+func TestCheckRestyResponse(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := resty.New()
+	start := time.Now()
+	resp, err := retryRequest(context.Background(), Options{MaxAttempts: 3}, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(client.R().SetContext(ctx).Get(server.URL))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp.String())
+	assert.Equal(t, 2, calls)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 1; attempt < 80; attempt++ {
+		delay := retryDelay(attempt, 0)
+		assert.Greater(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, retryMaxDelay)
+	}
+	assert.Equal(t, retryMaxDelay, retryDelay(1, time.Hour))
+	assert.Equal(t, parseRetryAfter("120"), 2*time.Minute)
+}
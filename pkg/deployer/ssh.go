@@ -0,0 +1,340 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type SshConfig struct {
+	// Hosts are host or host:port, port 22 is used if not given
+	Hosts []string `yaml:"hosts"`
+	User  string   `yaml:"user"`
+	// PrivateKey is the content of private key, PrivateKeyPath is read if it is empty
+	PrivateKey           string `yaml:"private_key"`
+	PrivateKeyPath       string `yaml:"private_key_path"`
+	PrivateKeyPassphrase string `yaml:"private_key_passphrase"`
+	// KnownHosts is the path of known_hosts file verifying host keys, ~/.ssh/known_hosts if empty
+	KnownHosts string `yaml:"known_hosts"`
+	// Cert, Chain, FullChain, Key and Combined are remote paths, see FileConfig
+	Cert      string `yaml:"cert"`
+	Chain     string `yaml:"chain"`
+	FullChain string `yaml:"fullchain"`
+	Key       string `yaml:"key"`
+	Combined  string `yaml:"combined"`
+	// Mode of certificate files, 0644 if empty
+	Mode string `yaml:"mode"`
+	// KeyMode of key and combined files, 0600 if empty
+	KeyMode string `yaml:"key_mode"`
+	// ReloadCommand runs on every host after files are uploaded
+	ReloadCommand string `yaml:"reload_command"`
+}
+
+type SshDeployer struct {
+	hosts        []string
+	clientConfig *ssh.ClientConfig
+	files        []fileTarget
+	reload       string
+	options      Options
+}
+
+var _ Deployer = (*SshDeployer)(nil)
+
+func init() {
+	Register(Define("ssh", "remote servers, files uploaded by sftp with a reload command run afterwards", SshConfigFromEnv, CreateSshDeployer))
+}
+
+func (*SshDeployer) Name() string {
+	return "ssh"
+}
+
+// Plan connects to every host to compare remote files with the certificate
+func (d *SshDeployer) Plan(ctx context.Context, _ []string, cert, key string) (*Plan, error) {
+	plan, _, err := d.plan(ctx, cert, key)
+	return plan, err
+}
+
+func (d *SshDeployer) Deploy(ctx context.Context, _ []string, cert, key string) (*Result, error) {
+	plan, pems, err := d.plan(ctx, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	err = deployEach(ctx, d.options, result, plan.Resources(), func(host string) string { return host }, func(ctx context.Context, host string) error {
+		return d.deployHost(ctx, host, pems)
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, result.Err()
+}
+
+func (d *SshDeployer) plan(ctx context.Context, cert, key string) (*Plan, *filePems, error) {
+	pems, err := splitPems(cert, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan := &Plan{}
+	for _, host := range d.hosts {
+		if d.options.Force {
+			plan.Add(host, "forced")
+			continue
+		}
+		changed, err := d.changedFiles(ctx, host, pems)
+		if err != nil {
+			// hosts unreachable now are tried again when deploying
+			log.Printf("failed to compare files on %s: %s", host, err)
+			plan.Add(host, fmt.Sprintf("unable to compare files: %s", err))
+		} else if len(changed) == 0 {
+			plan.Skip(host, ActionSkippedUnchanged, "all files have the same content")
+		} else {
+			plan.Add(host, fmt.Sprintf("%s differ", strings.Join(changed, ", ")))
+		}
+	}
+	return plan, pems, nil
+}
+
+func (d *SshDeployer) changedFiles(ctx context.Context, host string, pems *filePems) ([]string, error) {
+	changed := make([]string, 0)
+	err := d.withSftp(ctx, host, func(client *ssh.Client, sc *sftp.Client) error {
+		for _, f := range d.files {
+			current, err := readRemote(sc, f.path)
+			if err != nil || !bytes.Equal(current, f.content(*pems)) {
+				changed = append(changed, f.path)
+			}
+		}
+		return nil
+	})
+	return changed, err
+}
+
+func (d *SshDeployer) deployHost(ctx context.Context, host string, pems *filePems) error {
+	return d.withSftp(ctx, host, func(client *ssh.Client, sc *sftp.Client) error {
+		for _, f := range d.files {
+			err := writeRemote(sc, f.path, f.mode, f.content(*pems))
+			if err != nil {
+				return fmt.Errorf("failed to upload %s: %w", f.path, err)
+			}
+		}
+		if d.reload == "" {
+			return nil
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			return fmt.Errorf("failed to open session: %w", err)
+		}
+		defer session.Close()
+		output, err := session.CombinedOutput(d.reload)
+		if len(output) > 0 {
+			log.Printf("reload command output on %s: %s", host, strings.TrimSpace(string(output)))
+		}
+		if err != nil {
+			return fmt.Errorf("reload command failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// withSftp connects to host, and closes the connection once f returns or ctx is done
+func (d *SshDeployer) withSftp(ctx context.Context, host string, f func(client *ssh.Client, sc *sftp.Client) error) error {
+	dialer := net.Dialer{Timeout: d.options.RequestTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	config := *d.clientConfig
+	config.HostKeyAlgorithms = knownHostKeyAlgorithms(config.HostKeyCallback, host, conn.RemoteAddr())
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, &config)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to handshake: %w", err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %w", err)
+	}
+	defer sc.Close()
+	return f(client, sc)
+}
+
+func readRemote(sc *sftp.Client, name string) ([]byte, error) {
+	file, err := sc.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// knownHostKeyAlgorithms lists algorithms of keys known for host, so that the server does not offer another type of key.
+// It is nil for unknown hosts, which are then rejected by callback.
+func knownHostKeyAlgorithms(callback ssh.HostKeyCallback, host string, remote net.Addr) []string {
+	// known keys are reported as mismatched when checking a key never seen
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(host, remote, probe), &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+	algorithms := make([]string, 0, len(keyErr.Want))
+	for _, known := range keyErr.Want {
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, known.Key.Type())
+	}
+	return algorithms
+}
+
+// writeRemote replaces remote file atomically by renaming a temporary file in the same directory
+func writeRemote(sc *sftp.Client, name string, mode os.FileMode, content []byte) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+"."+hex.EncodeToString(suffix)+".certdeploy.tmp")
+	file, err := sc.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	// restrict mode before writing, the key must not be readable by others even for a moment
+	err = file.Chmod(mode)
+	if err == nil {
+		_, err = file.Write(content)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = sc.PosixRename(tmp, name)
+	}
+	if err != nil {
+		_ = sc.Remove(tmp)
+	}
+	return err
+}
+
+func SshConfigFromEnv() SshConfig {
+	config := SshConfig{
+		User:                 os.Getenv("SSH_USER"),
+		PrivateKey:           os.Getenv("SSH_PRIVATE_KEY"),
+		PrivateKeyPath:       os.Getenv("SSH_PRIVATE_KEY_PATH"),
+		PrivateKeyPassphrase: os.Getenv("SSH_PRIVATE_KEY_PASSPHRASE"),
+		KnownHosts:           os.Getenv("SSH_KNOWN_HOSTS"),
+		Cert:                 os.Getenv("SSH_CERT_PATH"),
+		Chain:                os.Getenv("SSH_CHAIN_PATH"),
+		FullChain:            os.Getenv("SSH_FULLCHAIN_PATH"),
+		Key:                  os.Getenv("SSH_KEY_PATH"),
+		Combined:             os.Getenv("SSH_COMBINED_PATH"),
+		Mode:                 os.Getenv("SSH_MODE"),
+		KeyMode:              os.Getenv("SSH_KEY_MODE"),
+		ReloadCommand:        os.Getenv("SSH_RELOAD_COMMAND"),
+	}
+	if hosts := os.Getenv("SSH_HOSTS"); hosts != "" {
+		config.Hosts = strings.Split(hosts, ",")
+	}
+	return config
+}
+
+func CreateSshDeployer(config SshConfig, options Options) (*SshDeployer, error) {
+	mode, err := parseFileMode(config.Mode, 0644)
+	if err != nil {
+		return nil, err
+	}
+	keyMode, err := parseFileMode(config.KeyMode, 0600)
+	if err != nil {
+		return nil, err
+	}
+	files := pemFileTargets(config.Cert, config.Chain, config.FullChain, config.Key, config.Combined, mode, keyMode)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no remote file path given")
+	}
+
+	hosts := make([]string, 0, len(config.Hosts))
+	for _, host := range config.Hosts {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "22")
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no ssh host given")
+	}
+
+	privateKey := []byte(config.PrivateKey)
+	if len(privateKey) == 0 {
+		if config.PrivateKeyPath == "" {
+			return nil, fmt.Errorf("no ssh private key given")
+		}
+		privateKey, err = os.ReadFile(config.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh private key: %w", err)
+		}
+	}
+	var signer ssh.Signer
+	if config.PrivateKeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte(config.PrivateKeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(privateKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh private key: %w", err)
+	}
+
+	knownHostsPath := config.KnownHosts
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find known_hosts: %w", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+
+	deployer := SshDeployer{
+		hosts: hosts,
+		clientConfig: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+		},
+		files:   files,
+		reload:  config.ReloadCommand,
+		options: options,
+	}
+	return &deployer, nil
+}
@@ -1,16 +1,30 @@
 package deployer
 
 import (
+	"context"
 	"fmt"
 	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	"log"
+	"math"
 	"os"
+	"time"
 )
 
+type TencentCloudConfig struct {
+	SecretId  string `yaml:"secret_id"`
+	SecretKey string `yaml:"secret_key"`
+	// UpdateOnly only updates certs for CDN domains with SSL enabled
+	UpdateOnly bool `yaml:"update_only"`
+}
+
+// tencentCloudTimezone is the timezone of times returned by tencent cloud APIs
+var tencentCloudTimezone = time.FixedZone("CST", 8*60*60)
+
 type TencentCloudDeployer struct {
 	client     *cdn.Client
+	options    Options
 	updateOnly bool
 }
 
@@ -18,13 +32,51 @@ func (*TencentCloudDeployer) Name() string {
 	return "tencentcloud"
 }
 
+// Plan finds all CDN domains matching domains contains in certificate
+func (d *TencentCloudDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	plan := &Plan{}
+	_, err := d.findDomains(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
 // Deploy deploys cert and key to all related domains, while domains indicate the domains contains in certificate
-func (d *TencentCloudDeployer) Deploy(domains []string, cert, key string) error {
-	if len(domains) < 1 {
+func (d *TencentCloudDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan := &Plan{}
+	cdnDomains, err := d.findDomains(ctx, domains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+
+	err = deployEach(ctx, d.options, result, cdnDomains, func(cdnDomain *cdn.DetailDomain) string { return *cdnDomain.Domain }, func(ctx context.Context, cdnDomain *cdn.DetailDomain) error {
+		err := d.deployCert(ctx, cdnDomain, cert, key)
+		if err != nil {
+			return fmt.Errorf("failed to deploy domain %s: %w", *cdnDomain.Domain, err)
+		}
 		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, result.Err()
+}
+
+func (d *TencentCloudDeployer) findDomains(ctx context.Context, domains []string, cert string, plan *Plan) ([]*cdn.DetailDomain, error) {
+	found := make([]*cdn.DetailDomain, 0)
+	if len(domains) < 1 {
+		return found, nil
+	}
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Println("getting tencent cloud CDN domains matching given certificates")
+	seen := make(map[string]bool)
 	for _, domain := range domains {
 		normalizedDomain := normalizeWildcardDomain(domain)
 		fuzzy := false
@@ -45,15 +97,36 @@ func (d *TencentCloudDeployer) Deploy(domains []string, cert, key string) error
 					Fuzzy: common.BoolPtr(fuzzy),
 				},
 			}
-			cdnDomains, err := d.client.DescribeDomainsConfig(request)
+			cdnDomains, err := retryRequest(ctx, d.options, func(ctx context.Context) (*cdn.DescribeDomainsConfigResponse, error) {
+				return d.client.DescribeDomainsConfigWithContext(ctx, request)
+			})
 			if err != nil {
-				return fmt.Errorf("failed to describe user domains with suffix %s: %w", normalizedDomain, err)
+				return nil, fmt.Errorf("failed to describe user domains with suffix %s: %w", normalizedDomain, err)
 			}
 			for _, cdnDomain := range cdnDomains.Response.Domains {
+				if cdnDomain.Domain == nil || seen[*cdnDomain.Domain] {
+					continue
+				}
+				if !checkCovered(domains, *cdnDomain.Domain, normalizedDomain) {
+					seen[*cdnDomain.Domain] = true
+					continue
+				}
+				if cdnDomain.Status != nil && !d.checkDomainStatus(*cdnDomain.Status) {
+					seen[*cdnDomain.Domain] = true
+					plan.Skip(*cdnDomain.Domain, ActionSkippedInactive, fmt.Sprintf("matches %s but is %s", domain, *cdnDomain.Status))
+					continue
+				}
 				if d.checkDomainDeploy(cdnDomain) {
-					err := d.deployCert(cdnDomain, cert, key)
-					if err != nil {
-						return fmt.Errorf("failed to deploy domain %s: %w", *cdnDomain.Domain, err)
+					seen[*cdnDomain.Domain] = true
+					if !d.options.Force && d.servesCertificate(cdnDomain, info) {
+						plan.Skip(*cdnDomain.Domain, ActionSkippedUnchanged, info.unchangedReason())
+						continue
+					}
+					found = append(found, cdnDomain)
+					if d.updateOnly {
+						plan.Add(*cdnDomain.Domain, fmt.Sprintf("matches %s and has HTTPS enabled", domain))
+					} else {
+						plan.Add(*cdnDomain.Domain, fmt.Sprintf("matches %s", domain))
 					}
 				}
 			}
@@ -65,14 +138,15 @@ func (d *TencentCloudDeployer) Deploy(domains []string, cert, key string) error
 		}
 	}
 
-	return nil
+	log.Printf("got %d domains to deploy", len(found))
+	return found, nil
 }
 
 func (d *TencentCloudDeployer) checkDomainDeploy(cdnDomain *cdn.DetailDomain) bool {
 	if cdnDomain.Domain == nil || cdnDomain.Https == nil || cdnDomain.Status == nil {
 		return false
 	}
-	if *cdnDomain.Status != "online" && *cdnDomain.Status != "processing" {
+	if !d.checkDomainStatus(*cdnDomain.Status) {
 		return false
 	}
 	if d.updateOnly {
@@ -82,8 +156,27 @@ func (d *TencentCloudDeployer) checkDomainDeploy(cdnDomain *cdn.DetailDomain) bo
 	}
 }
 
-func (d *TencentCloudDeployer) deployCert(cdnDomain *cdn.DetailDomain, cert string, key string) error {
-	log.Printf("deploying cert for domain: %s", *cdnDomain.Domain)
+// servesCertificate compares the deployed certificate, or its expiry time if certificate content is not returned
+func (d *TencentCloudDeployer) servesCertificate(cdnDomain *cdn.DetailDomain, info *certificateInfo) bool {
+	if cdnDomain.Https == nil || cdnDomain.Https.Switch == nil || *cdnDomain.Https.Switch != "on" || cdnDomain.Https.CertInfo == nil {
+		return false
+	}
+	certInfo := cdnDomain.Https.CertInfo
+	if certInfo.Certificate != nil && *certInfo.Certificate != "" {
+		return info.matchesPem(*certInfo.Certificate)
+	}
+	if certInfo.ExpireTime != nil {
+		expireTime, err := time.ParseInLocation("2006-01-02 15:04:05", *certInfo.ExpireTime, tencentCloudTimezone)
+		return err == nil && info.matchesExpiry(expireTime)
+	}
+	return false
+}
+
+func (d *TencentCloudDeployer) checkDomainStatus(status string) bool {
+	return status == "online" || status == "processing"
+}
+
+func (d *TencentCloudDeployer) deployCert(ctx context.Context, cdnDomain *cdn.DetailDomain, cert string, key string) error {
 	if cdnDomain.Https == nil {
 		cdnDomain.Https = &cdn.Https{
 			Switch: common.StringPtr("on"),
@@ -101,7 +194,9 @@ func (d *TencentCloudDeployer) deployCert(cdnDomain *cdn.DetailDomain, cert stri
 	request.Domain = cdnDomain.Domain
 	request.Https = cdnDomain.Https
 
-	_, err := d.client.UpdateDomainConfig(request)
+	_, err := retryRequest(ctx, d.options, func(ctx context.Context) (*cdn.UpdateDomainConfigResponse, error) {
+		return d.client.UpdateDomainConfigWithContext(ctx, request)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to call update domain api: %w", err)
 	}
@@ -110,12 +205,24 @@ func (d *TencentCloudDeployer) deployCert(cdnDomain *cdn.DetailDomain, cert stri
 
 var _ Deployer = (*TencentCloudDeployer)(nil)
 
-func CreateTencentCloudDeployer() (*TencentCloudDeployer, error) {
-	credentials := common.NewCredential(
-		os.Getenv("TENCENTCLOUD_SECRET_ID"),
-		os.Getenv("TENCENTCLOUD_SECRET_KEY"),
-	)
+func init() {
+	Register(Define("tencentcloud", "Tencent Cloud CDN domains", TencentCloudConfigFromEnv, CreateTencentCloudDeployer))
+}
+
+func TencentCloudConfigFromEnv() TencentCloudConfig {
+	return TencentCloudConfig{
+		SecretId:   os.Getenv("TENCENTCLOUD_SECRET_ID"),
+		SecretKey:  os.Getenv("TENCENTCLOUD_SECRET_KEY"),
+		UpdateOnly: os.Getenv("TENCENTCLOUD_CERT_UPDATE_ONLY") == "true",
+	}
+}
+
+func CreateTencentCloudDeployer(config TencentCloudConfig, options Options) (*TencentCloudDeployer, error) {
+	credentials := common.NewCredential(config.SecretId, config.SecretKey)
 	cpf := profile.NewClientProfile()
+	if options.RequestTimeout > 0 {
+		cpf.HttpProfile.ReqTimeout = int(math.Ceil(options.RequestTimeout.Seconds()))
+	}
 
 	client, err := cdn.NewClient(credentials, "", cpf)
 	if err != nil {
@@ -124,7 +231,8 @@ func CreateTencentCloudDeployer() (*TencentCloudDeployer, error) {
 
 	deployer := TencentCloudDeployer{
 		client:     client,
-		updateOnly: os.Getenv("TENCENTCLOUD_CERT_UPDATE_ONLY") == "true",
+		options:    options,
+		updateOnly: config.UpdateOnly,
 	}
 
 	return &deployer, nil
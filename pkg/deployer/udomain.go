@@ -1,16 +1,24 @@
 package deployer
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-resty/resty/v2"
 	"github.com/oott123/certdeploy/pkg/util"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
+type UDomainConfig struct {
+	ApiKey string `yaml:"api_key"`
+}
+
 type UDomainDeployer struct {
-	apiKey string
+	apiKey   string
+	endpoint string
+	options  Options
 }
 
 type getSubDomainResult struct {
@@ -65,88 +73,158 @@ type postCertificateRequest struct {
 	PublicKey       string `json:"publicKey"`
 }
 
+type udomainSubdomain struct {
+	id       int
+	resource string
+}
+
 var _ Deployer = (*UDomainDeployer)(nil)
 
+func init() {
+	Register(Define("udomain", "UDomain CDN subdomains", UDomainConfigFromEnv, CreateUDomainDeployer))
+}
+
 func (*UDomainDeployer) Name() string {
 	return "udomain"
 }
 
-// Deploy deploys cert and key to all related domains, while domains indicate the domains contains in certificate
-func (d *UDomainDeployer) Deploy(domains []string, cert, key string) error {
-	c := resty.New().SetHeader("Authorization", d.apiKey).SetBaseURL("https://cdn.8338.hk/api")
-
-	response := getSubDomainResult{
-		Code: "failed",
-	}
-	_, err := c.R().SetResult(&response).SetError(&response).Get("/c/v1/subdomain")
+// Plan finds all subdomains matching domains contains in certificate.
+// They are never skipped as unchanged, since no documented API lists uploaded certificates or the one a subdomain uses.
+func (d *UDomainDeployer) Plan(ctx context.Context, domains []string, _, _ string) (*Plan, error) {
+	plan := &Plan{}
+	_, err := d.findSubdomains(ctx, d.client(), domains, plan)
 	if err != nil {
-		return fmt.Errorf("failed to volcRequest domain: %w", err)
+		return nil, err
 	}
-	if response.Code != "0" {
-		return fmt.Errorf("failed to get domain %s(%s)", response.Code, response.Message)
+	return plan, nil
+}
+
+// Deploy deploys cert and key to all related domains, while domains indicate the domains contains in certificate
+func (d *UDomainDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	c := d.client()
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
 	}
 
-	subdomainIds := make([]int, 0)
-	for _, subdomain := range response.Payload {
-		for _, domainInCert := range domains {
-			if util.MatchDomain(domainInCert, subdomain.SubdomainName) &&
-				(subdomain.SubdomainStatus == "ACTIVE" || subdomain.SubdomainStatus == "PROCESSING") {
-				log.Printf("queued to update domain %s(#%d)", subdomain.SubdomainName, subdomain.SubdomainID)
-				subdomainIds = append(subdomainIds, subdomain.SubdomainID)
-				break
-			}
-		}
+	plan := &Plan{}
+	subdomains, err := d.findSubdomains(ctx, c, domains, plan)
+	if err != nil {
+		return nil, err
 	}
+	result := NewResult(plan)
 
-	if len(subdomainIds) <= 0 {
+	if len(subdomains) <= 0 {
 		log.Printf("unable to find domains suited for certificate")
-		return nil
+		return result, nil
 	}
 
-	// upload certificate
-	certRequest := postCertificateRequest{
-		CertificateName: fmt.Sprintf("%s(%s)", domains[0], time.Now().UTC().Format("2006-01-02")),
-		PrivateKey:      key,
-		PublicKey:       cert,
-	}
-	certResult := postCertificateResult{
-		Code: "failed",
-	}
-	_, err = c.R().SetResult(&certResult).SetError(&certResult).SetBody(&certRequest).Post("/c/v1/certificate")
+	certId, err := d.uploadCertificate(ctx, c, info.primaryName(), cert, key)
 	if err != nil {
-		return fmt.Errorf("failed to upload certificate volcRequest: %w", err)
-	}
-	if certResult.Code != "0" {
-		return fmt.Errorf("failed to upload certificate %s(%s)", certResult.Code, certResult.Message)
+		for _, subdomain := range subdomains {
+			result.Failed(subdomain.resource, err)
+		}
+		return result, err
 	}
-	certId := certResult.Payload.CertificateID
-	log.Printf("successfully uploaded certificate #%d", certId)
 	// apply certificate
-	for _, subdomainId := range subdomainIds {
+	err = deployEach(ctx, d.options, result, subdomains, func(subdomain udomainSubdomain) string { return subdomain.resource }, func(ctx context.Context, subdomain udomainSubdomain) error {
 		request := postConfigurationRequest{
 			ConfigCategory: "HTTPS",
 			ConfigItem:     "CERTIFICATE",
-			SubdomainID:    subdomainId,
+			SubdomainID:    subdomain.id,
 			ConfigValue: struct {
 				CertificateID int `json:"certificateID"`
 			}{CertificateID: certId},
 		}
-		result := postConfigurationResult{
+		configResult := postConfigurationResult{
 			Code: "failed",
 		}
-		r, err := c.R().SetBody(&request).SetError(&result).Put("/c/v1/configuration")
+		r, err := retryRequest(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+			return checkRestyResponse(c.R().SetContext(ctx).SetBody(&request).SetError(&configResult).Put("/c/v1/configuration"))
+		})
 		if err != nil {
 			return fmt.Errorf("failed to update domain volcRequest: %w", err)
+		} else if r.StatusCode() > 299 {
+			return fmt.Errorf("failed to update domain: %s %s", configResult.Code, configResult.Message)
 		}
-		if r.StatusCode() > 299 {
-			return fmt.Errorf("failed to update domain: %s %s", result.Code, result.Message)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, result.Err()
+}
+
+func (d *UDomainDeployer) uploadCertificate(ctx context.Context, c *resty.Client, name, cert, key string) (int, error) {
+	certRequest := postCertificateRequest{
+		CertificateName: fmt.Sprintf("%s(%s)", name, time.Now().UTC().Format("2006-01-02")),
+		PrivateKey:      key,
+		PublicKey:       cert,
+	}
+	certResult := postCertificateResult{
+		Code: "failed",
+	}
+	_, err := retryMutation(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(c.R().SetContext(ctx).SetResult(&certResult).SetError(&certResult).SetBody(&certRequest).Post("/c/v1/certificate"))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload certificate volcRequest: %w", err)
+	}
+	if certResult.Code != "0" {
+		return 0, fmt.Errorf("failed to upload certificate %s(%s)", certResult.Code, certResult.Message)
+	}
+	log.Printf("successfully uploaded certificate #%d", certResult.Payload.CertificateID)
+	return certResult.Payload.CertificateID, nil
+}
+
+func UDomainConfigFromEnv() UDomainConfig {
+	return UDomainConfig{ApiKey: os.Getenv("UDOMAIN_API_KEY")}
+}
+
+func (d *UDomainDeployer) client() *resty.Client {
+	return resty.New().SetHeader("Authorization", d.apiKey).SetBaseURL(d.endpoint).SetTimeout(d.options.RequestTimeout)
+}
+
+func (d *UDomainDeployer) findSubdomains(ctx context.Context, c *resty.Client, domains []string, plan *Plan) ([]udomainSubdomain, error) {
+	response := getSubDomainResult{
+		Code: "failed",
+	}
+	_, err := retryRequest(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(c.R().SetContext(ctx).SetResult(&response).SetError(&response).Get("/c/v1/subdomain"))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to volcRequest domain: %w", err)
+	}
+	if response.Code != "0" {
+		return nil, fmt.Errorf("failed to get domain %s(%s)", response.Code, response.Message)
+	}
+
+	subdomains := make([]udomainSubdomain, 0)
+	for _, subdomain := range response.Payload {
+		for _, domainInCert := range domains {
+			if !util.MatchDomain(domainInCert, subdomain.SubdomainName) {
+				continue
+			}
+			resource := fmt.Sprintf("%s(#%d)", subdomain.SubdomainName, subdomain.SubdomainID)
+			status := strings.ToLower(subdomain.SubdomainStatus)
+			if subdomain.SubdomainStatus == "ACTIVE" || subdomain.SubdomainStatus == "PROCESSING" {
+				log.Printf("queued to update domain %s", resource)
+				subdomains = append(subdomains, udomainSubdomain{id: subdomain.SubdomainID, resource: resource})
+				plan.Add(resource, fmt.Sprintf("matches %s and is %s", domainInCert, status))
+			} else {
+				plan.Skip(resource, ActionSkippedInactive, fmt.Sprintf("matches %s but is %s", domainInCert, status))
+			}
+			break
 		}
-		log.Printf("successfully updated domain #%d", subdomainId)
 	}
-	return nil
+	return subdomains, nil
+}
+
+func CreateUDomainDeployer(config UDomainConfig, options Options) (*UDomainDeployer, error) {
+	return newUDomainDeployer(config, "https://cdn.8338.hk/api", options)
 }
 
-func CreateUDomainDeployer() (*UDomainDeployer, error) {
-	deployer := UDomainDeployer{apiKey: os.Getenv("UDOMAIN_API_KEY")}
+func newUDomainDeployer(config UDomainConfig, endpoint string, options Options) (*UDomainDeployer, error) {
+	deployer := UDomainDeployer{apiKey: config.ApiKey, endpoint: endpoint, options: options}
 	return &deployer, nil
 }
@@ -0,0 +1,94 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// udomainMock serves certificate upload, subdomain and configuration APIs of udomain
+type udomainMock struct {
+	mu         sync.Mutex
+	names      []string
+	subdomains map[int]string
+	inactive   map[int]bool
+	configs    map[int]int
+	uploads    int
+}
+
+func (m *udomainMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "KEY" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"code":"401","message":"unauthorized"}`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/c/v1/certificate":
+		var body postCertificateRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.uploads++
+		m.names = append(m.names, body.CertificateName)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": "0", "payload": map[string]int{"certificateID": 100 + m.uploads}})
+	case r.Method == http.MethodGet && r.URL.Path == "/c/v1/subdomain":
+		payload := make([]map[string]interface{}, 0)
+		for id := 1; id <= len(m.subdomains); id++ {
+			status := "ACTIVE"
+			if m.inactive[id] {
+				status = "SUSPENDED"
+			}
+			payload = append(payload, map[string]interface{}{"subdomainID": id, "subdomainName": m.subdomains[id], "subdomainStatus": status})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": "0", "payload": payload})
+	case r.Method == http.MethodPut && r.URL.Path == "/c/v1/configuration":
+		var body postConfigurationRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.configs[body.SubdomainID] = body.ConfigValue.CertificateID
+		_, _ = w.Write([]byte(`{"code":"0"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code":"404","message":"not found"}`))
+	}
+}
+
+func TestUDomainDeployer_Deploy(t *testing.T) {
+	domains := []string{"*.example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	mock := &udomainMock{
+		subdomains: map[int]string{1: "a.example.com", 2: "b.example.com", 3: "c.example.com", 4: "z.other.com"},
+		inactive:   map[int]bool{3: true},
+		configs:    map[int]int{1: 1},
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	d, err := newUDomainDeployer(UDomainConfig{ApiKey: "KEY"}, server.URL, Options{})
+	assert.NoError(t, err)
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com(#1)", "b.example.com(#2)"}, plan.Resources())
+
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	assert.Equal(t, 1, result.Count(ActionSkippedInactive))
+	assert.Equal(t, 1, mock.uploads)
+	assert.Equal(t, map[int]int{1: 101, 2: 101}, mock.configs)
+	assert.Len(t, mock.names, 1)
+	assert.True(t, strings.HasPrefix(mock.names[0], "*.example.com("))
+
+	// nothing tells which certificate a subdomain uses, so it is uploaded and applied again
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	assert.Equal(t, 2, mock.uploads)
+	assert.Equal(t, map[int]int{1: 102, 2: 102}, mock.configs)
+}
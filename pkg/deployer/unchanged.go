@@ -0,0 +1,67 @@
+package deployer
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oott123/certdeploy/pkg/certparser"
+)
+
+// certificateInfo identifies the leaf certificate being deployed, to find targets already serving it
+type certificateInfo struct {
+	leaf        *x509.Certificate
+	fingerprint string
+}
+
+func newCertificateInfo(cert string) (*certificateInfo, error) {
+	certs, err := certparser.CertificatesFromPEM(cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return &certificateInfo{leaf: certs[0], fingerprint: certparser.Fingerprint(certs[0])}, nil
+}
+
+// primaryName is the common name of the leaf, or its first DNS name, which is stable between runs unlike domains in certificate
+func (c *certificateInfo) primaryName() string {
+	if c.leaf.Subject.CommonName != "" {
+		return c.leaf.Subject.CommonName
+	}
+	if len(c.leaf.DNSNames) > 0 {
+		return c.leaf.DNSNames[0]
+	}
+	return ""
+}
+
+// matchesPem reports whether the leaf of deployed PEM is the certificate being deployed
+func (c *certificateInfo) matchesPem(deployed string) bool {
+	certs, err := certparser.CertificatesFromPEM(deployed)
+	if err != nil || len(certs) == 0 {
+		return false
+	}
+	return c.matches(certs[0])
+}
+
+func (c *certificateInfo) matches(deployed *x509.Certificate) bool {
+	return certparser.Fingerprint(deployed) == c.fingerprint
+}
+
+// matchesFingerprint compares SHA-256 fingerprint in hex, while case and colons are ignored
+func (c *certificateInfo) matchesFingerprint(fingerprint string) bool {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", "")) == c.fingerprint
+}
+
+// matchesExpiry compares expiry time only, for APIs which do not expose the deployed certificate
+func (c *certificateInfo) matchesExpiry(notAfter time.Time) bool {
+	diff := c.leaf.NotAfter.Sub(notAfter)
+	return diff < time.Second && diff > -time.Second
+}
+
+func (c *certificateInfo) unchangedReason() string {
+	return fmt.Sprintf("already serves certificate %s (serial %s, expires %s)",
+		c.fingerprint[:16], c.leaf.SerialNumber.Text(16), c.leaf.NotAfter.Format(time.RFC3339))
+}
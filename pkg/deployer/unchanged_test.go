@@ -0,0 +1,25 @@
+package deployer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateInfo(t *testing.T) {
+	cert := testCertificatePem(t, 1)
+	other := testCertificatePem(t, 2)
+
+	info, err := newCertificateInfo(cert)
+	assert.NoError(t, err)
+
+	assert.True(t, info.matchesPem(cert))
+	assert.False(t, info.matchesPem(other))
+	assert.False(t, info.matchesPem("not a certificate"))
+
+	assert.True(t, info.matchesFingerprint(info.fingerprint))
+	assert.True(t, info.matchesFingerprint(strings.ToUpper(info.fingerprint)))
+	assert.False(t, info.matchesFingerprint(""))
+	assert.Contains(t, info.unchangedReason(), info.fingerprint[:16])
+}
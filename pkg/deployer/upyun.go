@@ -1,61 +1,105 @@
 package deployer
 
 import (
+	"context"
 	"fmt"
 	resty "github.com/go-resty/resty/v2"
+	"github.com/oott123/certdeploy/pkg/util"
 	gjson "github.com/tidwall/gjson"
 	"golang.org/x/net/publicsuffix"
 	"log"
 	"net/http/cookiejar"
+	"net/url"
 	"os"
 )
 
+type UpyunConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
 type UpyunDeployer struct {
 	username string
 	password string
 	jar      *cookiejar.Jar
 	client   *resty.Client
+	endpoint string
+	options  Options
 }
 
 func (u *UpyunDeployer) Name() string {
 	return "upyun"
 }
 
-func (u *UpyunDeployer) Deploy(_ []string, cert, key string) error {
+// Plan lists the certificate upload only, since upyun domains to update are only found after uploading.
+// No documented API lists uploaded certificates, so the certificate is uploaded on every deploy.
+func (u *UpyunDeployer) Plan(ctx context.Context, _ []string, cert, _ string) (*Plan, error) {
 	log.Println("upyun logging in")
-	err := u.Login()
+	err := u.Login(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("upyun login failed: %w", err)
+	}
+	info, err := newCertificateInfo(cert)
 	if err != nil {
-		return fmt.Errorf("upyun login failed: %w", err)
+		return nil, err
+	}
+
+	plan := &Plan{}
+	plan.Add("certificate "+info.primaryName(), "would be uploaded, upyun domains matching it are only known after upload")
+	return plan, nil
+}
+
+func (u *UpyunDeployer) Deploy(ctx context.Context, certDomains []string, cert, key string) (*Result, error) {
+	log.Println("upyun logging in")
+	err := u.Login(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("upyun login failed: %w", err)
 	}
 
 	log.Println("upyun uploading certificate")
-	certId, err := u.UploadCertificate(cert, key)
+	certId, err := u.UploadCertificate(ctx, cert, key)
 	if err != nil {
-		return fmt.Errorf("upyun upload cert failed: %w", err)
+		return nil, fmt.Errorf("upyun upload cert failed: %w", err)
 	}
 
 	log.Printf("upyun certificate id: %s, getting domains", certId)
-	domains, err := u.DomainsByCertificate(certId)
+	found, err := u.DomainsByCertificate(ctx, certId)
 	if err != nil {
-		return fmt.Errorf("upyun get domains failed: %w", err)
+		return nil, fmt.Errorf("upyun get domains failed: %w", err)
+	}
+	plan := &Plan{}
+	domains := make([]string, 0, len(found))
+	for _, domain := range found {
+		if !util.MatchDomains(certDomains, domain) {
+			log.Printf("upyun domain %s does not match certificate, ignoring", domain)
+			continue
+		}
+		domains = append(domains, domain)
+		plan.Add(domain, "found by upyun for certificate")
 	}
 
-	for _, domain := range domains {
-		log.Printf("deploing certificate for domain: %s", domain)
-		err = u.SetDomainCertificate(certId, domain)
+	result := NewResult(plan)
+	err = deployEach(ctx, u.options, result, domains, func(domain string) string { return domain }, func(ctx context.Context, domain string) error {
+		err := u.SetDomainCertificate(ctx, certId, domain)
 		if err != nil {
 			return fmt.Errorf("upyun set domain certificate failed: %w", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return result, err
 	}
 
-	return nil
+	return result, result.Err()
 }
 
-func (u *UpyunDeployer) Login() error {
-	resp, err := u.client.R().SetBody(map[string]string{
-		"username": u.username,
-		"password": u.password,
-	}).Post("https://console.upyun.com/accounts/signin/")
+func (u *UpyunDeployer) Login(ctx context.Context) error {
+	resp, err := retryRequest(ctx, u.options, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(u.client.R().SetContext(ctx).SetBody(map[string]string{
+			"username": u.username,
+			"password": u.password,
+		}).Post(u.endpoint + "/accounts/signin/"))
+	})
 
 	if err = checkApiResult(resp, err); err != nil {
 		return fmt.Errorf("failed to login: %w", err)
@@ -64,11 +108,13 @@ func (u *UpyunDeployer) Login() error {
 	return nil
 }
 
-func (u *UpyunDeployer) UploadCertificate(cert, key string) (string, error) {
-	resp, err := u.client.R().SetBody(map[string]string{
-		"certificate": cert,
-		"private_key": key,
-	}).Post("https://console.upyun.com/api/https/certificate/")
+func (u *UpyunDeployer) UploadCertificate(ctx context.Context, cert, key string) (string, error) {
+	resp, err := retryMutation(ctx, u.options, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(u.client.R().SetContext(ctx).SetBody(map[string]string{
+			"certificate": cert,
+			"private_key": key,
+		}).Post(u.endpoint + "/api/https/certificate/"))
+	})
 
 	if err = checkApiResult(resp, err); err != nil {
 		return "", fmt.Errorf("failed to upload: %w", err)
@@ -77,32 +123,35 @@ func (u *UpyunDeployer) UploadCertificate(cert, key string) (string, error) {
 	return gjson.Get(resp.String(), "data.result.certificate_id").String(), nil
 }
 
-func (u *UpyunDeployer) DomainsByCertificate(certId string) ([]string, error) {
-	resp, err := u.client.R().Get("https://console.upyun.com/api/https/certificate/manager/?certificate_id=" + certId)
+func (u *UpyunDeployer) DomainsByCertificate(ctx context.Context, certId string) ([]string, error) {
+	resp, err := retryRequest(ctx, u.options, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(u.client.R().SetContext(ctx).Get(u.endpoint + "/api/https/certificate/manager/?certificate_id=" + url.QueryEscape(certId)))
+	})
 
 	if err = checkApiResult(resp, err); err != nil {
 		return nil, fmt.Errorf("failed to get domains: %w", err)
 	}
 
 	domains := make([]string, 0)
-	list := gjson.Get(resp.String(), "data.domains.#.name")
-	for _, item := range list.Array() {
-		domains = append(domains, item.String())
+	for _, item := range gjson.Get(resp.String(), "data.domains").Array() {
+		domains = append(domains, item.Get("name").String())
 	}
 
 	return domains, nil
 }
 
-func (u *UpyunDeployer) SetDomainCertificate(certId string, domain string) error {
-	resp, err := u.client.R().SetBody(map[string]interface{}{
-		"certificate_id": certId,
-		"domain":         domain,
-		"https":          true,
-	}).Post("https://console.upyun.com/api/https/certificate/manager/")
+func (u *UpyunDeployer) SetDomainCertificate(ctx context.Context, certId string, domain string) error {
+	resp, err := retryRequest(ctx, u.options, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(u.client.R().SetContext(ctx).SetBody(map[string]interface{}{
+			"certificate_id": certId,
+			"domain":         domain,
+			"https":          true,
+		}).Post(u.endpoint + "/api/https/certificate/manager/"))
+	})
 
 	if err = checkApiResult(resp, err); err != nil {
 		if gjson.Get(resp.String(), "data.error_code").String() == "21713" {
-			return u.MigrateDomainCertificate(certId, domain)
+			return u.MigrateDomainCertificate(ctx, certId, domain)
 		}
 		return fmt.Errorf("failed to set https: %w", err)
 	}
@@ -110,11 +159,13 @@ func (u *UpyunDeployer) SetDomainCertificate(certId string, domain string) error
 	return nil
 }
 
-func (u *UpyunDeployer) MigrateDomainCertificate(certId, domain string) error {
-	resp, err := u.client.R().SetBody(map[string]string{
-		"crt_id":      certId,
-		"domain_name": domain,
-	}).Post("https://console.upyun.com/api/https/migrate/domain")
+func (u *UpyunDeployer) MigrateDomainCertificate(ctx context.Context, certId, domain string) error {
+	resp, err := retryRequest(ctx, u.options, func(ctx context.Context) (*resty.Response, error) {
+		return checkRestyResponse(u.client.R().SetContext(ctx).SetBody(map[string]string{
+			"crt_id":      certId,
+			"domain_name": domain,
+		}).Post(u.endpoint + "/api/https/migrate/domain"))
+	})
 
 	if err = checkApiResult(resp, err); err != nil {
 		return fmt.Errorf("failed to migrate domain: %w", err)
@@ -142,7 +193,22 @@ func checkApiResult(resp *resty.Response, err error) error {
 
 var _ Deployer = (*UpyunDeployer)(nil)
 
-func CreateUpyunDeployer() (*UpyunDeployer, error) {
+func init() {
+	Register(Define("upyun", "Upyun CDN domains, found by upyun after uploading certificate", UpyunConfigFromEnv, CreateUpyunDeployer))
+}
+
+func UpyunConfigFromEnv() UpyunConfig {
+	return UpyunConfig{
+		Username: os.Getenv("UPYUN_USERNAME"),
+		Password: os.Getenv("UPYUN_PASSWORD"),
+	}
+}
+
+func CreateUpyunDeployer(config UpyunConfig, options Options) (*UpyunDeployer, error) {
+	return newUpyunDeployer(config, "https://console.upyun.com", options)
+}
+
+func newUpyunDeployer(config UpyunConfig, endpoint string, options Options) (*UpyunDeployer, error) {
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 
 	if err != nil {
@@ -151,11 +217,14 @@ func CreateUpyunDeployer() (*UpyunDeployer, error) {
 
 	client := resty.New()
 	client.SetCookieJar(jar)
+	client.SetTimeout(options.RequestTimeout)
 
 	return &UpyunDeployer{
-		username: os.Getenv("UPYUN_USERNAME"),
-		password: os.Getenv("UPYUN_PASSWORD"),
+		username: config.Username,
+		password: config.Password,
 		jar:      jar,
 		client:   client,
+		endpoint: endpoint,
+		options:  options,
 	}, nil
 }
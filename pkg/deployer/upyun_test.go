@@ -1,38 +1,116 @@
 package deployer
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
+// upyunMock serves login, certificate upload and domain APIs of upyun console
+type upyunMock struct {
+	mu      sync.Mutex
+	certs   map[string]string
+	domains map[string]string
+	uploads int
+}
+
+func (m *upyunMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	data := map[string]interface{}{}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/accounts/signin/":
+		data["result"] = body["password"] == "PASSWORD"
+	case r.Method == http.MethodPost && r.URL.Path == "/api/https/certificate/":
+		m.uploads++
+		id := fmt.Sprintf("new-%d", m.uploads)
+		m.certs[id] = body["certificate"].(string)
+		data["result"] = map[string]string{"certificate_id": id}
+	case r.Method == http.MethodGet && r.URL.Path == "/api/https/certificate/manager/":
+		domains := make([]map[string]interface{}, 0)
+		for name := range m.domains {
+			domains = append(domains, map[string]interface{}{"name": name})
+		}
+		data["domains"] = domains
+	case r.Method == http.MethodPost && r.URL.Path == "/api/https/certificate/manager/":
+		m.domains[body["domain"].(string)] = body["certificate_id"].(string)
+		data["status"] = true
+	default:
+		data = map[string]interface{}{"error_code": 404, "message": "not found"}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func TestUpyunDeployer_Deploy(t *testing.T) {
+	domains := []string{"*.example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	mock := &upyunMock{
+		certs:   map[string]string{"old-1": testDomainsCertificatePem(t, domains...)},
+		domains: map[string]string{"a.example.com": "old-1", "b.example.com": "", "example.com": "", "a.b.example.com": ""},
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	d, err := newUpyunDeployer(UpyunConfig{Username: "USER", Password: "PASSWORD"}, server.URL, Options{})
+	assert.NoError(t, err)
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"certificate *.example.com"}, plan.Resources())
+
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	assert.Equal(t, 1, mock.uploads)
+	// domains returned by upyun but not matching certificate are left alone
+	assert.Equal(t, map[string]string{"a.example.com": "new-1", "b.example.com": "new-1", "example.com": "", "a.b.example.com": ""}, mock.domains)
+
+	// nothing tells which certificate a domain uses, so it is uploaded and applied again
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count(ActionUpdated))
+	assert.Equal(t, 2, mock.uploads)
+	assert.Equal(t, "new-2", mock.domains["a.example.com"])
+	assert.Equal(t, "new-2", mock.domains["b.example.com"])
+}
+
 func TestUpyunDeployer_Login(t *testing.T) {
-	u, err := CreateUpyunDeployer()
+	ctx := context.Background()
+	u, err := CreateUpyunDeployer(UpyunConfigFromEnv(), Options{})
 	if err != nil {
 		panic(err)
 	}
 
-	err = u.Login()
+	err = u.Login(ctx)
 	if err != nil {
 		panic(err)
 	}
 
-	id, err := u.UploadCertificate(readFile(os.Getenv("CERT_PATH")), readFile(os.Getenv("CERT_KEY_PATH")))
+	id, err := u.UploadCertificate(ctx, readFile(os.Getenv("CERT_PATH")), readFile(os.Getenv("CERT_KEY_PATH")))
 
 	if err != nil {
 		panic(err)
 	}
 
-	domains, err := u.DomainsByCertificate(id)
+	domains, err := u.DomainsByCertificate(ctx, id)
 	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("domains", strings.Join(domains, ","))
 
-	err = u.SetDomainCertificate(id, domains[0])
+	err = u.SetDomainCertificate(ctx, id, domains[0])
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,276 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/exp/slices"
+)
+
+type VaultConfig struct {
+	// Address is like https://vault.example.com:8200
+	Address   string `yaml:"address"`
+	Namespace string `yaml:"namespace"`
+	// Token authenticates directly, otherwise RoleId and SecretId login with AppRole
+	Token        string `yaml:"token"`
+	RoleId       string `yaml:"role_id"`
+	SecretId     string `yaml:"secret_id"`
+	ApproleMount string `yaml:"approle_mount"`
+	// Mount is the KV v2 secrets engine mount, secret if empty
+	Mount string `yaml:"mount"`
+	// Path is a template of secret path, {{ .Domain }} is the primary domain and {{ .Name }} is it with * replaced by wildcard
+	Path string `yaml:"path"`
+}
+
+type VaultDeployer struct {
+	client  *resty.Client
+	config  VaultConfig
+	path    *template.Template
+	token   string
+	options Options
+}
+
+// vaultSecret is the response of reading KV v2 secrets
+type vaultSecret struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+type vaultErrors struct {
+	Errors []string `json:"errors"`
+}
+
+var _ Deployer = (*VaultDeployer)(nil)
+
+func init() {
+	Register(Define("vault", "HashiCorp Vault KV v2 secrets", VaultConfigFromEnv, CreateVaultDeployer))
+}
+
+func (*VaultDeployer) Name() string {
+	return "vault"
+}
+
+// Plan reads the current version of secret to compare fingerprint
+func (d *VaultDeployer) Plan(ctx context.Context, domains []string, cert, _ string) (*Plan, error) {
+	plan, _, _, err := d.plan(ctx, cert)
+	return plan, err
+}
+
+func (d *VaultDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	plan, secretPath, version, err := d.plan(ctx, cert)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	if len(plan.Resources()) == 0 {
+		return result, nil
+	}
+	resource := plan.Resources()[0]
+
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+	pems, err := splitPems(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	// domains in certificate come in random order
+	sorted := slices.Clone(domains)
+	slices.Sort(sorted)
+	data := map[string]string{
+		"certificate": string(pems.leaf),
+		"chain":       string(pems.chain),
+		"fullchain":   string(concatBytes(pems.leaf, pems.chain)),
+		"private_key": string(pems.key),
+		"domains":     strings.Join(sorted, ","),
+		"fingerprint": info.fingerprint,
+		"serial":      info.leaf.SerialNumber.Text(16),
+		"not_before":  info.leaf.NotBefore.UTC().Format(time.RFC3339),
+		"not_after":   info.leaf.NotAfter.UTC().Format(time.RFC3339),
+	}
+
+	log.Printf("writing certificate to vault %s with version %d", resource, version)
+	var written struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	_, err = d.request(ctx, http.MethodPost, d.dataUrl(secretPath), map[string]interface{}{
+		// check-and-set fails if someone else wrote the secret since it was read
+		"options": map[string]int{"cas": version},
+		"data":    data,
+	}, &written)
+	if err != nil {
+		err = fmt.Errorf("failed to write secret: %w", err)
+		result.Failed(resource, err)
+		return result, err
+	}
+	log.Printf("written version %d of %s", written.Data.Version, resource)
+	result.Updated(resource)
+	return result, nil
+}
+
+// plan returns path of the secret and its current version, which is 0 if it does not exist
+func (d *VaultDeployer) plan(ctx context.Context, cert string) (*Plan, string, int, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	name := info.primaryName()
+	if name == "" {
+		return nil, "", 0, fmt.Errorf("no domain in certificate")
+	}
+	var buf bytes.Buffer
+	err = d.path.Execute(&buf, struct{ Domain, Name string }{
+		Domain: name,
+		Name:   strings.ReplaceAll(name, "*", "wildcard"),
+	})
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to render vault path: %w", err)
+	}
+	secretPath := strings.Trim(buf.String(), "/")
+	resource := d.config.Mount + "/" + secretPath
+
+	err = d.login(ctx)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	plan := &Plan{}
+	var current vaultSecret
+	resp, err := d.request(ctx, http.MethodGet, d.dataUrl(secretPath), nil, &current)
+	if resp != nil && resp.StatusCode() == http.StatusNotFound {
+		// the latest version is deleted or destroyed if metadata is still returned, check-and-set needs its version
+		var deleted vaultSecret
+		_ = d.client.JSONUnmarshal(resp.Body(), &deleted)
+		version := deleted.Data.Metadata.Version
+		if version > 0 {
+			plan.Add(resource, fmt.Sprintf("version %d is deleted", version))
+		} else {
+			plan.Add(resource, "secret does not exist")
+		}
+		return plan, secretPath, version, nil
+	}
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to read secret %s: %w", resource, err)
+	}
+	version := current.Data.Metadata.Version
+	if !d.options.Force && info.matchesFingerprint(current.Data.Data["fingerprint"]) {
+		plan.Skip(resource, ActionSkippedUnchanged, fmt.Sprintf("version %d %s", version, info.unchangedReason()))
+		return plan, secretPath, version, nil
+	}
+	plan.Add(resource, fmt.Sprintf("version %d holds another certificate", version))
+	return plan, secretPath, version, nil
+}
+
+// login gets a token with AppRole unless a token is given
+func (d *VaultDeployer) login(ctx context.Context) error {
+	if d.token != "" {
+		return nil
+	}
+	var auth struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	_, err := d.request(ctx, http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", d.config.ApproleMount), map[string]string{
+		"role_id":   d.config.RoleId,
+		"secret_id": d.config.SecretId,
+	}, &auth)
+	if err != nil {
+		return fmt.Errorf("failed to login vault with approle: %w", err)
+	}
+	if auth.Auth.ClientToken == "" {
+		return fmt.Errorf("failed to login vault with approle: no token returned")
+	}
+	d.token = auth.Auth.ClientToken
+	return nil
+}
+
+func (d *VaultDeployer) dataUrl(secretPath string) string {
+	return fmt.Sprintf("/v1/%s/data/%s", d.config.Mount, secretPath)
+}
+
+func (d *VaultDeployer) request(ctx context.Context, method, url string, body interface{}, result interface{}) (*resty.Response, error) {
+	resp, err := retryHttp(method)(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+		req := d.client.R().SetContext(ctx).SetResult(result)
+		if d.token != "" {
+			req.SetHeader("X-Vault-Token", d.token)
+		}
+		if body != nil {
+			req.SetBody(body)
+		}
+		return checkRestyResponse(req.Execute(method, url))
+	})
+	if err != nil {
+		return resp, err
+	}
+	if resp.IsError() {
+		var e vaultErrors
+		_ = d.client.JSONUnmarshal(resp.Body(), &e)
+		return resp, fmt.Errorf("vault returns %s: %s", resp.Status(), strings.Join(e.Errors, "; "))
+	}
+	return resp, nil
+}
+
+func VaultConfigFromEnv() VaultConfig {
+	return VaultConfig{
+		Address:      os.Getenv("VAULT_ADDR"),
+		Namespace:    os.Getenv("VAULT_NAMESPACE"),
+		Token:        os.Getenv("VAULT_TOKEN"),
+		RoleId:       os.Getenv("VAULT_ROLE_ID"),
+		SecretId:     os.Getenv("VAULT_SECRET_ID"),
+		ApproleMount: os.Getenv("VAULT_APPROLE_MOUNT"),
+		Mount:        os.Getenv("VAULT_KV_MOUNT"),
+		Path:         os.Getenv("VAULT_PATH"),
+	}
+}
+
+func CreateVaultDeployer(config VaultConfig, options Options) (*VaultDeployer, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("vault address is required")
+	}
+	if config.Token == "" && (config.RoleId == "" || config.SecretId == "") {
+		return nil, fmt.Errorf("vault token, or role id and secret id are required")
+	}
+	if config.ApproleMount == "" {
+		config.ApproleMount = "approle"
+	}
+	if config.Mount == "" {
+		config.Mount = "secret"
+	}
+	config.Mount = strings.Trim(config.Mount, "/")
+	if config.Path == "" {
+		config.Path = "certdeploy/{{ .Name }}"
+	}
+	path, err := template.New("path").Parse(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse vault path template: %w", err)
+	}
+
+	client := resty.New().SetBaseURL(strings.TrimSuffix(config.Address, "/")).SetTimeout(options.RequestTimeout)
+	if config.Namespace != "" {
+		client.SetHeader("X-Vault-Namespace", config.Namespace)
+	}
+	deployer := VaultDeployer{
+		client:  client,
+		config:  config,
+		path:    path,
+		token:   config.Token,
+		options: options,
+	}
+	return &deployer, nil
+}
@@ -0,0 +1,152 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// vaultStandIn serves approle login and a KV v2 mount named secret
+type vaultStandIn struct {
+	mu       sync.Mutex
+	secrets  map[string]map[string]string
+	versions map[string]int
+	// deleted are paths whose latest version is soft deleted
+	deleted map[string]bool
+	writes  int
+}
+
+func (s *vaultStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"approle-token"}}`))
+		return
+	}
+	if token := r.Header.Get("X-Vault-Token"); token != "root" && token != "approle-token" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	path := r.URL.Path[len("/v1/secret/data/"):]
+	switch r.Method {
+	case http.MethodGet:
+		if s.versions[path] == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		if s.deleted[path] {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"data":     nil,
+				"metadata": map[string]interface{}{"version": s.versions[path], "deletion_time": "2024-01-02T03:04:05Z"},
+			}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"data":     s.secrets[path],
+			"metadata": map[string]int{"version": s.versions[path]},
+		}})
+	case http.MethodPost:
+		var body struct {
+			Options struct {
+				Cas int `json:"cas"`
+			} `json:"options"`
+			Data map[string]string `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Options.Cas != s.versions[path] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+			return
+		}
+		s.writes++
+		s.deleted[path] = false
+		s.versions[path]++
+		s.secrets[path] = body.Data
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": s.versions[path]}})
+	}
+}
+
+func TestVaultDeployer_Deploy(t *testing.T) {
+	standIn := &vaultStandIn{secrets: map[string]map[string]string{}, versions: map[string]int{}, deleted: map[string]bool{}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	domains := []string{"*.example.com", "example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	d, err := CreateVaultDeployer(VaultConfig{Address: server.URL, RoleId: "role", SecretId: "secret"}, Options{})
+	assert.NoError(t, err)
+
+	plan, err := d.Plan(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/certdeploy/wildcard.example.com"}, plan.Resources())
+	assert.Equal(t, 0, standIn.writes)
+
+	result, err := d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Count(ActionUpdated))
+	secret := standIn.secrets["certdeploy/wildcard.example.com"]
+	assert.Equal(t, cert, secret["certificate"])
+	assert.Equal(t, "KEY\n", secret["private_key"])
+	assert.Equal(t, "*.example.com,example.com", secret["domains"])
+
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Count(ActionSkippedUnchanged))
+	assert.Equal(t, 1, standIn.writes)
+
+	// domains in certificate come in random order, path is the same in every run
+	result, err = d.Deploy(context.Background(), []string{"example.com", "*.example.com"}, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, []ResultEntry{{Resource: "secret/certdeploy/wildcard.example.com", Action: ActionSkippedUnchanged}}, result.Entries)
+	assert.Equal(t, 1, standIn.writes)
+
+	cert = testDomainsCertificatePem(t, domains...)
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Count(ActionUpdated))
+	assert.Equal(t, 2, standIn.versions["certdeploy/wildcard.example.com"])
+
+	// soft deleted latest version is replaced with check-and-set on its version
+	standIn.deleted["certdeploy/wildcard.example.com"] = true
+	result, err = d.Deploy(context.Background(), domains, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Count(ActionUpdated))
+	assert.Equal(t, 3, standIn.versions["certdeploy/wildcard.example.com"])
+}
+
+func TestVaultDeployer_Errors(t *testing.T) {
+	standIn := &vaultStandIn{secrets: map[string]map[string]string{}, versions: map[string]int{}, deleted: map[string]bool{}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	_, err := CreateVaultDeployer(VaultConfig{Address: server.URL}, Options{})
+	assert.Error(t, err)
+
+	domains := []string{"example.com"}
+	cert := testDomainsCertificatePem(t, domains...)
+	d, err := CreateVaultDeployer(VaultConfig{Address: server.URL, Token: "wrong"}, Options{})
+	assert.NoError(t, err)
+	_, err = d.Plan(context.Background(), domains, cert, "KEY")
+	assert.ErrorContains(t, err, "permission denied")
+
+	d, err = CreateVaultDeployer(VaultConfig{Address: server.URL, RoleId: "role", SecretId: "wrong"}, Options{})
+	assert.NoError(t, err)
+	_, err = d.Plan(context.Background(), domains, cert, "KEY")
+	assert.ErrorContains(t, err, "invalid role or secret ID")
+}
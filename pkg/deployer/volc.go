@@ -1,8 +1,10 @@
 package deployer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/oott123/certdeploy/pkg/util"
 	volcBase "github.com/volcengine/volc-sdk-golang/base"
 	"github.com/volcengine/volc-sdk-golang/service/cdn"
 	"golang.org/x/exp/slices"
@@ -15,46 +17,209 @@ import (
 	"time"
 )
 
+type VolcConfig struct {
+	AccessKeyId     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// DeployTargets can contain cdn and dcdn, all of them are deployed if empty
+	DeployTargets []string `yaml:"deploy_targets"`
+}
+
 type VolcDeployer struct {
-	cCdn  *cdn.CDN
-	cDcdn *volcBase.Client
+	cCdn    *cdn.CDN
+	cDcdn   *volcBase.Client
+	targets []string
+	options Options
 }
 
 func (v *VolcDeployer) Name() string {
 	return "volc"
 }
 
-func (v *VolcDeployer) Deploy(certDomains []string, cert, key string) error {
-	err, certId := v.uploadCertificate(cert, key)
+type volcTargets struct {
+	// certId is the id of the same certificate uploaded before, empty if not found
+	certId      string
+	cdnDomains  []string
+	dcdnDomains []volcDcdnDomain
+}
+
+type volcDcdnDomain struct {
+	id       string
+	resource string
+}
+
+func (v *VolcDeployer) Plan(ctx context.Context, certDomains []string, cert, _ string) (*Plan, error) {
+	plan := &Plan{}
+	_, err := v.findTargets(ctx, certDomains, cert, plan)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (v *VolcDeployer) Deploy(ctx context.Context, certDomains []string, cert, key string) (*Result, error) {
+	plan := &Plan{}
+	targets, err := v.findTargets(ctx, certDomains, cert, plan)
+	if err != nil {
+		return nil, err
+	}
+	result := NewResult(plan)
+	if len(targets.cdnDomains) == 0 && len(targets.dcdnDomains) == 0 {
+		log.Printf("unable to find domains suited for certificate")
+		return result, nil
+	}
+
+	certId := targets.certId
+	if certId != "" {
+		log.Printf("reusing uploaded cert id %s", certId)
+	} else {
+		err, certId = v.uploadCertificate(ctx, cert, key)
+		if err != nil {
+			for _, resource := range plan.Resources() {
+				result.Failed(resource, err)
+			}
+			return result, err
+		}
+	}
+
+	if len(targets.cdnDomains) > 0 {
+		err = v.deployCdn(ctx, result, certId, targets.cdnDomains)
+		if err != nil && !v.options.ContinueOnError {
+			result.NotAttempted(plan)
+			return result, err
+		}
+	}
+
+	if len(targets.dcdnDomains) > 0 {
+		err = v.deployDcdn(ctx, result, certId, targets.dcdnDomains)
+		if err != nil && !v.options.ContinueOnError {
+			return result, err
+		}
+	}
+
+	return result, result.Err()
+}
+
+func (v *VolcDeployer) findTargets(ctx context.Context, certDomains []string, cert string, plan *Plan) (*volcTargets, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
 	}
 
-	targetStr := os.Getenv("VOLC_DEPLOY_TARGETS")
-	if targetStr == "" {
-		targetStr = "cdn,dcdn"
+	targets := &volcTargets{}
+	targets.certId, err = v.findCertificate(ctx, info)
+	if err != nil {
+		return nil, fmt.Errorf("find uploaded cert: %w", err)
+	}
+	unchanged := func(string) bool { return false }
+	if targets.certId != "" && !v.options.Force {
+		unchanged = func(certId string) bool { return certId == targets.certId }
 	}
-	targets := strings.Split(targetStr, ",")
 
-	if slices.Contains(targets, "cdn") {
-		err = v.deployCdn(certId)
+	if slices.Contains(v.targets, "cdn") {
+		targets.cdnDomains, err = v.findCdnDomains(ctx, certDomains, info, targets.certId, plan)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("cdn list domains: %w", err)
 		}
 	}
 
-	if slices.Contains(targets, "dcdn") {
-		err = v.deployDcdn(certDomains, certId)
+	if slices.Contains(v.targets, "dcdn") {
+		targets.dcdnDomains, err = v.findDcdnDomains(ctx, certDomains, info, unchanged, plan)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("dcdn list cert binds: %w", err)
 		}
 	}
 
-	return nil
+	return targets, nil
+}
+
+// findCertificate finds the same certificate in cert center by its fingerprint, to avoid uploading it again
+func (v *VolcDeployer) findCertificate(ctx context.Context, info *certificateInfo) (string, error) {
+	var pageNum int64 = 1
+	var pageSize int64 = 100
+	for {
+		err, resp := volcRequest[cdn.ListCertInfoResult](ctx, v, v.cCdn.Client, "ListCertInfo", &cdn.ListCertInfoRequest{
+			Source:   "volc_cert_center",
+			PageNum:  &pageNum,
+			PageSize: &pageSize,
+		})
+		if err != nil {
+			return "", fmt.Errorf("list cert info page %d: %w", pageNum, err)
+		}
+		for _, certInfo := range resp.CertInfo {
+			if info.matchesFingerprint(certInfo.CertFingerprint.Sha256) {
+				return certInfo.CertId, nil
+			}
+		}
+		if resp.Total <= pageNum*pageSize {
+			return "", nil
+		}
+		pageNum++
+	}
+}
+
+// domainsServingCertificate finds CDN domains which are already configured with certId
+func (v *VolcDeployer) domainsServingCertificate(ctx context.Context, certId string) (map[string]bool, error) {
+	domains := make(map[string]bool)
+	if certId == "" || v.options.Force {
+		return domains, nil
+	}
+	err, resp := volcRequest[cdn.DescribeCertConfigResult](ctx, v, v.cCdn.Client, "DescribeCertConfig", &cdn.DescribeCertConfigRequest{
+		CertId: certId,
+		Status: cdn.GetStrPtr("configuring,online"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe volc cert %s: %w", certId, err)
+	}
+	for _, dom := range resp.SpecifiedCertConfig {
+		domains[dom.Domain] = true
+	}
+	return domains, nil
+}
+
+func (v *VolcDeployer) findCdnDomains(ctx context.Context, certDomains []string, info *certificateInfo, certId string, plan *Plan) ([]string, error) {
+	unchanged, err := v.domainsServingCertificate(ctx, certId)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make([]string, 0)
+	var pageNum int64 = 1
+	var pageSize int64 = 100
+	for {
+		err, resp := volcRequest[cdn.ListCdnDomainsResult](ctx, v, v.cCdn.Client, "ListCdnDomains", &cdn.ListCdnDomainsRequest{
+			PageNum:  &pageNum,
+			PageSize: &pageSize,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list cdn domains page %d: %w", pageNum, err)
+		}
+		for _, dom := range resp.Data {
+			if !matchDomain(certDomains, []string{dom.Domain}) {
+				continue
+			}
+			if dom.Status != "online" && dom.Status != "configuring" {
+				plan.Skip(dom.Domain, ActionSkippedInactive, fmt.Sprintf("cdn domain matches certificate but is %s", dom.Status))
+				continue
+			}
+			if unchanged[dom.Domain] {
+				plan.Skip(dom.Domain, ActionSkippedUnchanged, info.unchangedReason())
+				continue
+			}
+			domains = append(domains, dom.Domain)
+			plan.Add(dom.Domain, fmt.Sprintf("cdn domain is %s and matches certificate", dom.Status))
+		}
+		if resp.Total <= pageNum*pageSize {
+			break
+		}
+		pageNum++
+	}
+
+	log.Printf("got %d CDN domains to update", len(domains))
+	return domains, nil
 }
 
-func (v *VolcDeployer) uploadCertificate(cert string, key string) (error, string) {
-	certResp, err := v.cCdn.AddCdnCertificate(&cdn.AddCdnCertificateRequest{
+func (v *VolcDeployer) uploadCertificate(ctx context.Context, cert string, key string) (error, string) {
+	err, certId := volcRequest[string](ctx, v, v.cCdn.Client, "AddCdnCertificate", &cdn.AddCdnCertificateRequest{
 		Certificate: cdn.Certificate{
 			Certificate: cdn.GetStrPtr(cert),
 			PrivateKey:  cdn.GetStrPtr(key),
@@ -68,38 +233,39 @@ func (v *VolcDeployer) uploadCertificate(cert string, key string) (error, string
 		return fmt.Errorf("create volc cert: %w", err), ""
 	}
 
-	certId := certResp.Result
-	log.Printf("uploaded cert id %s", certId)
-	return nil, certId
+	log.Printf("uploaded cert id %s", *certId)
+	return nil, *certId
 }
 
-func (v *VolcDeployer) deployCdn(certId string) error {
-	var err error
-	configResp, err := v.cCdn.DescribeCertConfig(&cdn.DescribeCertConfigRequest{
-		CertId: certId,
-		Status: cdn.GetStrPtr("configuring,online"),
-	})
-	if err != nil {
-		return fmt.Errorf("describe volc cert %s: %w", certId, err)
-	}
-
-	domains := make([]string, 0)
-	for _, dom := range configResp.Result.CertNotConfig {
-		domains = append(domains, dom.Domain)
-	}
-	for _, dom := range configResp.Result.OtherCertConfig {
-		domains = append(domains, dom.Domain)
-	}
-
-	log.Printf("got %d CDN domains to update", len(domains))
-	err = batch(domains, 50, func(chunk []string) error {
+func (v *VolcDeployer) deployCdn(ctx context.Context, result *Result, certId string, domains []string) error {
+	err := batch(domains, 50, func(chunk []string) error {
 		log.Printf("deploying %s", strings.Join(chunk, ", "))
-		_, err = v.cCdn.BatchDeployCert(&cdn.BatchDeployCertRequest{
+		err, resp := volcRequest[cdn.BatchDeployCertResult](ctx, v, v.cCdn.Client, "BatchDeployCert", &cdn.BatchDeployCertRequest{
 			CertId: certId,
 			Domain: strings.Join(chunk, ","),
 		})
 		if err != nil {
-			return fmt.Errorf("deploying cert: %w", err)
+			err = fmt.Errorf("deploying cert: %w", err)
+			stop := false
+			for _, domain := range chunk {
+				stop = v.options.failed(result, domain, err)
+			}
+			if stop {
+				return err
+			}
+			return nil
+		}
+		failed := 0
+		for _, status := range resp.DeployResult {
+			if strings.EqualFold(status.Status, "success") {
+				result.Updated(status.Domain)
+			} else {
+				failed++
+				result.Failed(status.Domain, fmt.Errorf("deploying cert: %s", status.ErrorMsg))
+			}
+		}
+		if failed > 0 && !v.options.ContinueOnError {
+			return fmt.Errorf("deploying cert: %d domains failed", failed)
 		}
 		return nil
 	})
@@ -111,36 +277,54 @@ func (v *VolcDeployer) deployCdn(certId string) error {
 	return nil
 }
 
-func (v *VolcDeployer) deployDcdn(certDomains []string, certId string) error {
-	err, bindRes := v.listCertBind()
+func (v *VolcDeployer) findDcdnDomains(ctx context.Context, certDomains []string, info *certificateInfo, unchanged func(certId string) bool, plan *Plan) ([]volcDcdnDomain, error) {
+	err, bindRes := v.listCertBind(ctx)
 	if err != nil {
-		return fmt.Errorf("dcdn list cert binds: %w", err)
+		return nil, err
 	}
 
-	domainIds := make([]string, 0)
+	domains := make([]volcDcdnDomain, 0)
 	for _, bind := range bindRes.BindList {
 		cdnDomains := strings.Split(bind.DomainName, ",")
 		matched := matchDomain(certDomains, cdnDomains)
 		log.Printf("checking dcdn domains: %s, matched: %v", cdnDomains, matched)
 		if matched {
-			domainIds = append(domainIds, bind.DomainId)
+			resource := fmt.Sprintf("%s(#%s)", bind.DomainName, bind.DomainId)
+			if unchanged(bind.CertId) {
+				plan.Skip(resource, ActionSkippedUnchanged, info.unchangedReason())
+				continue
+			}
+			domains = append(domains, volcDcdnDomain{id: bind.DomainId, resource: resource})
+			plan.Add(resource, "dcdn domains all match certificate")
 		}
 	}
 
-	log.Printf("got %d domains to deploy for dcdn", len(domainIds))
-	if len(domainIds) > 0 {
-		log.Printf("domain ids: %s", strings.Join(domainIds, ", "))
-		err = v.createCertBind(certId, domainIds)
-		if err != nil {
-			return fmt.Errorf("dcdn create cert bind: %w", err)
+	log.Printf("got %d domains to deploy for dcdn", len(domains))
+	return domains, nil
+}
+
+func (v *VolcDeployer) deployDcdn(ctx context.Context, result *Result, certId string, domains []volcDcdnDomain) error {
+	domainIds := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domainIds = append(domainIds, domain.id)
+	}
+	log.Printf("domain ids: %s", strings.Join(domainIds, ", "))
+	err := v.createCertBind(ctx, certId, domainIds)
+	if err != nil {
+		err = fmt.Errorf("dcdn create cert bind: %w", err)
+		for _, domain := range domains {
+			result.Failed(domain.resource, err)
 		}
+		return err
+	}
+	for _, domain := range domains {
+		result.Updated(domain.resource)
 	}
-
 	return nil
 }
 
-func (v *VolcDeployer) listCertBind() (error, *DcdnListCertBindResponse) {
-	err, resp := volcRequest[DcdnListCertBindResponse](v, "ListCertBind", &DcdnListCertBindRequest{
+func (v *VolcDeployer) listCertBind(ctx context.Context) (error, *DcdnListCertBindResponse) {
+	err, resp := volcRequest[DcdnListCertBindResponse](ctx, v, v.cDcdn, "ListCertBind", &DcdnListCertBindRequest{
 		ProjectName: nil,
 		SearchKey:   nil,
 	})
@@ -150,8 +334,8 @@ func (v *VolcDeployer) listCertBind() (error, *DcdnListCertBindResponse) {
 	return nil, resp
 }
 
-func (v *VolcDeployer) createCertBind(certId string, domainIds []string) error {
-	err, _ := volcRequest[interface{}](v, "CreateCertBind", &DcdnCreateCertBindRequest{
+func (v *VolcDeployer) createCertBind(ctx context.Context, certId string, domainIds []string) error {
+	err, _ := volcRequest[interface{}](ctx, v, v.cDcdn, "CreateCertBind", &DcdnCreateCertBindRequest{
 		CertId:     certId,
 		DomainIds:  domainIds,
 		CertSource: "volc",
@@ -162,27 +346,50 @@ func (v *VolcDeployer) createCertBind(certId string, domainIds []string) error {
 	return nil
 }
 
-func volcRequest[TResult any](v *VolcDeployer, api string, body interface{}) (error, *TResult) {
+// volcMutations are APIs creating something, which are not retried once they may have been processed
+var volcMutations = map[string]bool{
+	"AddCdnCertificate": true,
+}
+
+func volcRequest[TResult any](ctx context.Context, v *VolcDeployer, client *volcBase.Client, api string, body interface{}) (error, *TResult) {
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
 		return fmt.Errorf("marshal json: %w", err), nil
 	}
 
-	respBytes, _, err := v.cDcdn.Json(api, url.Values{}, string(bodyBytes))
-	if err != nil {
-		return fmt.Errorf("volcRequest %s: %w", api, err), nil
+	retry := retryRequest[*ResponseBody[TResult]]
+	if volcMutations[api] {
+		retry = retryMutation[*ResponseBody[TResult]]
 	}
+	resp, err := retry(ctx, v.options, func(ctx context.Context) (*ResponseBody[TResult], error) {
+		respBytes, status, err := client.CtxJson(ctx, api, url.Values{}, string(bodyBytes))
+		if err != nil {
+			err = fmt.Errorf("volcRequest %s: %w", api, err)
+			if retryableStatus(status) {
+				err = &retryableError{err: err, unprocessed: status == http.StatusTooManyRequests}
+			}
+			return nil, err
+		}
 
-	var resp ResponseBody[TResult]
-	err = json.Unmarshal(respBytes, &resp)
-	if err != nil {
-		return fmt.Errorf("unmarshal response: %w", err), nil
-	}
+		var resp ResponseBody[TResult]
+		err = json.Unmarshal(respBytes, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal response: %w", err)
+		}
 
-	if resp.ResponseMetadata.Error != nil {
-		return fmt.Errorf("[%s] %s", resp.ResponseMetadata.Error.Code, resp.ResponseMetadata.Error.Message), nil
+		if e := resp.ResponseMetadata.Error; e != nil {
+			err = fmt.Errorf("[%s] %s", e.Code, e.Message)
+			throttled := strings.Contains(e.Code, "LimitExceeded") || strings.HasPrefix(e.Code, "Throttling")
+			if throttled || e.Code == "InternalError" || e.Code == "ServiceUnavailable" {
+				err = &retryableError{err: err, unprocessed: throttled}
+			}
+			return nil, err
+		}
+		return &resp, nil
+	})
+	if err != nil {
+		return err, nil
 	}
-
 	return nil, &resp.Result
 }
 
@@ -268,45 +475,52 @@ type ResponseBody[TResult any] struct {
 
 var _ Deployer = (*VolcDeployer)(nil)
 
+func init() {
+	Register(Define("volc", "Volc Engine CDN and DCDN domains", VolcConfigFromEnv, CreateVolcDeployer))
+}
+
+// matchDomain checks whether all cdnDomains are covered by certDomains
 func matchDomain(certDomains []string, cdnDomains []string) bool {
-	for _, cdnDom := range cdnDomains {
-		matched := false
-		cdnDom = strings.ToLower(cdnDom)
-		for _, certDom := range certDomains {
-			certDomLower := normalizeWildcardDomain(certDom)
-			pos := strings.Index(cdnDom, certDomLower)
-			if pos == -1 {
-				continue
-			}
-			subPart := cdnDom[0:pos]
-			if strings.Index(subPart, ".") == -1 {
-				matched = true
-			}
-		}
-		// all cert domains are not matching cdn domain
-		if !matched {
-			return false
-		}
-	}
+	return util.CoverDomains(certDomains, cdnDomains)
+}
 
-	return true
+func VolcConfigFromEnv() VolcConfig {
+	config := VolcConfig{
+		AccessKeyId:     os.Getenv("VOLC_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("VOLC_SECRET_ACCESS_KEY"),
+	}
+	if targets := os.Getenv("VOLC_DEPLOY_TARGETS"); targets != "" {
+		config.DeployTargets = strings.Split(targets, ",")
+	}
+	return config
 }
 
-func CreateVolcDeployer() (*VolcDeployer, error) {
+func CreateVolcDeployer(config VolcConfig, options Options) (*VolcDeployer, error) {
+	timeout := options.RequestTimeout
+	if timeout <= 0 {
+		timeout = time.Minute * 5
+	}
+
 	cCdn := cdn.NewInstance()
-	cCdn.Client.SetAccessKey(os.Getenv("VOLC_ACCESS_KEY_ID"))
-	cCdn.Client.SetSecretKey(os.Getenv("VOLC_SECRET_ACCESS_KEY"))
+	cCdn.Client.SetAccessKey(config.AccessKeyId)
+	cCdn.Client.SetSecretKey(config.SecretAccessKey)
+	cCdn.Client.SetTimeout(timeout)
+
+	targets := config.DeployTargets
+	if len(targets) == 0 {
+		targets = []string{"cdn", "dcdn"}
+	}
 
 	cDcdn := volcBase.NewClient(&volcBase.ServiceInfo{
-		Timeout: time.Minute * 5,
+		Timeout: timeout,
 		Host:    "open.volcengineapi.com",
 		Header: http.Header{
 			"Accept":       []string{"application/json"},
 			"Content-Type": []string{"application/json"},
 		},
 		Credentials: volcBase.Credentials{
-			AccessKeyID:     os.Getenv("VOLC_ACCESS_KEY_ID"),
-			SecretAccessKey: os.Getenv("VOLC_SECRET_ACCESS_KEY"),
+			AccessKeyID:     config.AccessKeyId,
+			SecretAccessKey: config.SecretAccessKey,
 			Service:         "dcdn",
 			Region:          "cn-beijing",
 		},
@@ -337,5 +551,5 @@ func CreateVolcDeployer() (*VolcDeployer, error) {
 		},
 	})
 
-	return &VolcDeployer{cCdn: cCdn, cDcdn: cDcdn}, nil
+	return &VolcDeployer{cCdn: cCdn, cDcdn: cDcdn, targets: targets, options: options}, nil
 }
@@ -1,18 +1,19 @@
 package deployer
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
 func TestVolcDeployer_Deploy(t *testing.T) {
-	v, err := CreateVolcDeployer()
+	v, err := CreateVolcDeployer(VolcConfigFromEnv(), Options{})
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	err, _ = v.listCertBind()
+	err, _ = v.listCertBind(context.Background())
 	if err != nil {
 		t.Error(err)
 		return
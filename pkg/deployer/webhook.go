@@ -0,0 +1,283 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/tidwall/gjson"
+	"golang.org/x/exp/slices"
+)
+
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Method is POST if empty
+	Method string `yaml:"method"`
+	// Format is json or multipart, json if empty
+	Format string `yaml:"format"`
+	// Template renders json body from WebhookPayload, the payload is sent as is if empty
+	Template string            `yaml:"template"`
+	Headers  map[string]string `yaml:"headers"`
+	// BearerToken is sent as Authorization header if given
+	BearerToken string `yaml:"bearer_token"`
+	// HmacSecret signs request body with HMAC-SHA256 if given
+	HmacSecret string `yaml:"hmac_secret"`
+	// HmacHeader is the header carrying signature, X-Certdeploy-Signature if empty
+	HmacHeader string `yaml:"hmac_header"`
+	// SuccessStatus lists status codes considered successful, any 2xx if empty
+	SuccessStatus []int `yaml:"success_status"`
+	// SuccessPath is a gjson path in response, which must be true, or equal to SuccessValue if given
+	SuccessPath  string `yaml:"success_path"`
+	SuccessValue string `yaml:"success_value"`
+}
+
+// WebhookPayload is the data sent to webhook, and the data of body template
+type WebhookPayload struct {
+	Domains     []string  `json:"domains"`
+	Cert        string    `json:"cert"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+type WebhookDeployer struct {
+	config   WebhookConfig
+	template *template.Template
+	client   *resty.Client
+	options  Options
+}
+
+func (*WebhookDeployer) Name() string {
+	return "webhook"
+}
+
+// Plan only reports the endpoint, since the webhook decides what to update
+func (d *WebhookDeployer) Plan(_ context.Context, _ []string, _, _ string) (*Plan, error) {
+	plan := &Plan{}
+	plan.Add(d.resource(), fmt.Sprintf("certificate would be sent as %s", d.config.Format))
+	return plan, nil
+}
+
+func (d *WebhookDeployer) Deploy(ctx context.Context, domains []string, cert, key string) (*Result, error) {
+	info, err := newCertificateInfo(cert)
+	if err != nil {
+		return nil, err
+	}
+	payload := WebhookPayload{
+		Domains:     domains,
+		Cert:        cert,
+		Key:         key,
+		Fingerprint: info.fingerprint,
+		Serial:      info.leaf.SerialNumber.Text(16),
+		NotBefore:   info.leaf.NotBefore.UTC(),
+		NotAfter:    info.leaf.NotAfter.UTC(),
+	}
+	body, contentType, err := d.body(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	log.Printf("sending certificate to %s", d.resource())
+	// receivers reloading on every request are not idempotent
+	resp, err := retryHttp(d.config.Method)(ctx, d.options, func(ctx context.Context) (*resty.Response, error) {
+		req := d.client.R().SetContext(ctx).SetBody(body).SetHeader("Content-Type", contentType)
+		for name, value := range d.config.Headers {
+			req.SetHeader(name, value)
+		}
+		if d.config.BearerToken != "" {
+			req.SetAuthToken(d.config.BearerToken)
+		}
+		if d.config.HmacSecret != "" {
+			req.SetHeader(d.config.HmacHeader, signWebhook(d.config.HmacSecret, body))
+		}
+		return checkRestyResponse(req.Execute(d.config.Method, d.config.URL))
+	})
+	if err == nil {
+		err = d.checkResponse(resp)
+	}
+	if err != nil {
+		err = fmt.Errorf("webhook failed: %w", err)
+		result.Failed(d.resource(), err)
+		return result, err
+	}
+	result.Updated(d.resource())
+	return result, nil
+}
+
+func (d *WebhookDeployer) body(payload WebhookPayload) ([]byte, string, error) {
+	if d.config.Format == "multipart" {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		files := map[string]string{"cert": payload.Cert, "key": payload.Key}
+		for _, name := range []string{"cert", "key"} {
+			part, err := w.CreateFormFile(name, name+".pem")
+			if err != nil {
+				return nil, "", err
+			}
+			_, _ = part.Write([]byte(files[name]))
+		}
+		fields := [][2]string{
+			{"domains", strings.Join(payload.Domains, ",")},
+			{"fingerprint", payload.Fingerprint},
+			{"serial", payload.Serial},
+			{"not_before", payload.NotBefore.Format(time.RFC3339)},
+			{"not_after", payload.NotAfter.Format(time.RFC3339)},
+		}
+		for _, field := range fields {
+			if err := w.WriteField(field[0], field[1]); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), w.FormDataContentType(), nil
+	}
+
+	if d.template == nil {
+		body, err := json.Marshal(payload)
+		return body, "application/json", err
+	}
+	var buf bytes.Buffer
+	err := d.template.Execute(&buf, payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to render webhook template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, "", fmt.Errorf("webhook template renders invalid json")
+	}
+	return buf.Bytes(), "application/json", nil
+}
+
+func (d *WebhookDeployer) checkResponse(resp *resty.Response) error {
+	status := resp.StatusCode()
+	if len(d.config.SuccessStatus) > 0 {
+		if !slices.Contains(d.config.SuccessStatus, status) {
+			return fmt.Errorf("unexpected status %s: %s", resp.Status(), resp.String())
+		}
+	} else if status < 200 || status > 299 {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status(), resp.String())
+	}
+
+	if d.config.SuccessPath == "" {
+		return nil
+	}
+	value := gjson.Get(resp.String(), d.config.SuccessPath)
+	if d.config.SuccessValue != "" {
+		if value.String() != d.config.SuccessValue {
+			return fmt.Errorf("%s is %q instead of %q: %s", d.config.SuccessPath, value.String(), d.config.SuccessValue, resp.String())
+		}
+	} else if !value.Bool() {
+		return fmt.Errorf("%s is not true: %s", d.config.SuccessPath, resp.String())
+	}
+	return nil
+}
+
+// resource is the webhook url without query, which might contain secrets
+func (d *WebhookDeployer) resource() string {
+	u, err := url.Parse(d.config.URL)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+// signWebhook signs body in the form of sha256=<hex digest>
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var _ Deployer = (*WebhookDeployer)(nil)
+
+func init() {
+	Register(Define("webhook", "HTTP endpoint receiving certificate as json or multipart form", WebhookConfigFromEnv, CreateWebhookDeployer))
+}
+
+func WebhookConfigFromEnv() WebhookConfig {
+	config := WebhookConfig{
+		URL:          os.Getenv("WEBHOOK_URL"),
+		Method:       os.Getenv("WEBHOOK_METHOD"),
+		Format:       os.Getenv("WEBHOOK_FORMAT"),
+		Template:     os.Getenv("WEBHOOK_TEMPLATE"),
+		BearerToken:  os.Getenv("WEBHOOK_BEARER_TOKEN"),
+		HmacSecret:   os.Getenv("WEBHOOK_HMAC_SECRET"),
+		HmacHeader:   os.Getenv("WEBHOOK_HMAC_HEADER"),
+		SuccessPath:  os.Getenv("WEBHOOK_SUCCESS_PATH"),
+		SuccessValue: os.Getenv("WEBHOOK_SUCCESS_VALUE"),
+	}
+	// WEBHOOK_HEADERS looks like Name: value, separated by newlines
+	for _, line := range strings.Split(os.Getenv("WEBHOOK_HEADERS"), "\n") {
+		name, value, found := strings.Cut(line, ":")
+		if found {
+			if config.Headers == nil {
+				config.Headers = make(map[string]string)
+			}
+			config.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	for _, status := range strings.Split(os.Getenv("WEBHOOK_SUCCESS_STATUS"), ",") {
+		if code, err := strconv.Atoi(strings.TrimSpace(status)); err == nil {
+			config.SuccessStatus = append(config.SuccessStatus, code)
+		}
+	}
+	return config
+}
+
+func CreateWebhookDeployer(config WebhookConfig, options Options) (*WebhookDeployer, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+	config.Method = strings.ToUpper(config.Method)
+	if config.Method == "" {
+		config.Method = "POST"
+	}
+	if config.Format == "" {
+		config.Format = "json"
+	}
+	if config.Format != "json" && config.Format != "multipart" {
+		return nil, fmt.Errorf("unknown webhook format %s, should be json or multipart", config.Format)
+	}
+	if config.HmacHeader == "" {
+		config.HmacHeader = "X-Certdeploy-Signature"
+	}
+
+	deployer := WebhookDeployer{
+		config:  config,
+		client:  resty.New().SetTimeout(options.RequestTimeout),
+		options: options,
+	}
+	if config.Template != "" {
+		if config.Format != "json" {
+			return nil, fmt.Errorf("webhook template only works with json format")
+		}
+		t, err := template.New("webhook").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse webhook template: %w", err)
+		}
+		deployer.template = t
+	}
+	return &deployer, nil
+}
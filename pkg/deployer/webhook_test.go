@@ -0,0 +1,132 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookTestRequest is what the test checks of a received webhook request
+type webhookTestRequest struct {
+	Method   string
+	Header   http.Header
+	FormErr  error
+	Domains  string
+	CertFile string
+}
+
+func TestWebhookDeployer(t *testing.T) {
+	cert := testCertificatePem(t, 1)
+	// request is copied in handler since the server still uses *http.Request after handler returns
+	var mu sync.Mutex
+	var request webhookTestRequest
+	var body []byte
+	response := `{"ok": true}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		request = webhookTestRequest{Method: r.Method, Header: r.Header.Clone()}
+		body, _ = io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			request.FormErr = r.ParseMultipartForm(1 << 20)
+			request.Domains = r.FormValue("domains")
+			if file, _, err := r.FormFile("cert"); err == nil {
+				content, _ := io.ReadAll(file)
+				request.CertFile = string(content)
+			}
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	d, err := CreateWebhookDeployer(WebhookConfig{
+		URL:         server.URL + "/certs?token=secret",
+		Headers:     map[string]string{"X-Service": "frontend"},
+		BearerToken: "token",
+		HmacSecret:  "secret",
+		SuccessPath: "ok",
+	}, Options{})
+	assert.NoError(t, err)
+
+	result, err := d.Deploy(context.Background(), []string{"example.com"}, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/certs", result.Entries[0].Resource)
+	assert.Equal(t, ActionUpdated, result.Entries[0].Action)
+	assert.Equal(t, "POST", request.Method)
+	assert.Equal(t, "frontend", request.Header.Get("X-Service"))
+	assert.Equal(t, "Bearer token", request.Header.Get("Authorization"))
+	assert.Equal(t, signWebhook("secret", body), request.Header.Get("X-Certdeploy-Signature"))
+	var payload map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, cert, payload["cert"])
+	assert.Equal(t, "KEY", payload["key"])
+	assert.Equal(t, []interface{}{"example.com"}, payload["domains"])
+	assert.Len(t, payload["fingerprint"], 64)
+
+	mu.Lock()
+	response = `{"ok": false}`
+	mu.Unlock()
+	_, err = d.Deploy(context.Background(), []string{"example.com"}, cert, "KEY")
+	assert.ErrorContains(t, err, "ok is not true")
+
+	d, err = CreateWebhookDeployer(WebhookConfig{
+		URL:           server.URL,
+		Method:        "PUT",
+		Template:      `{"pem": {{ json .Cert }}, "names": {{ json .Domains }}, "expires": {{ json .NotAfter.Unix }}}`,
+		SuccessStatus: []int{200},
+		SuccessPath:   "ok",
+		SuccessValue:  "false",
+	}, Options{})
+	assert.NoError(t, err)
+	_, err = d.Deploy(context.Background(), []string{"example.com"}, cert, "KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "PUT", request.Method)
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, cert, payload["pem"])
+	assert.Equal(t, []interface{}{"example.com"}, payload["names"])
+
+	d, err = CreateWebhookDeployer(WebhookConfig{URL: server.URL, Format: "multipart"}, Options{})
+	assert.NoError(t, err)
+	_, err = d.Deploy(context.Background(), []string{"example.com", "www.example.com"}, cert, "KEY")
+	assert.NoError(t, err)
+	assert.NoError(t, request.FormErr)
+	assert.Equal(t, "example.com,www.example.com", request.Domains)
+	assert.Equal(t, cert, request.CertFile)
+}
+
+func TestWebhookDeployer_Retry(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// the receiver may have reloaded already, POST is not sent again
+	d, err := CreateWebhookDeployer(WebhookConfig{URL: server.URL}, Options{MaxAttempts: 3})
+	assert.NoError(t, err)
+	_, err = d.Deploy(context.Background(), []string{"example.com"}, testCertificatePem(t, 1), "KEY")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	d, err = CreateWebhookDeployer(WebhookConfig{URL: server.URL, Method: "put"}, Options{MaxAttempts: 3})
+	assert.NoError(t, err)
+	_, err = d.Deploy(context.Background(), []string{"example.com"}, testCertificatePem(t, 1), "KEY")
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
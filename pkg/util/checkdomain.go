@@ -1,27 +1,66 @@
 package util
 
-import "strings"
+import (
+	"strings"
 
+	"golang.org/x/net/idna"
+)
+
+// MatchDomain checks whether domainInCert, which might be a wildcard, matches domainInService following RFC 6125:
+// wildcard is only allowed as the whole leftmost label and matches exactly one label.
 func MatchDomain(domainInCert, domainInService string) bool {
-	domainInCert = normalizeDomain(domainInCert)
-	domainInService = normalizeDomain(domainInService)
+	domainInCert = NormalizeDomain(domainInCert)
+	domainInService = NormalizeDomain(domainInService)
+	if domainInCert == "" || domainInService == "" {
+		return false
+	}
+	if domainInCert == domainInService {
+		return true
+	}
 
-	if strings.Index(domainInCert, "*") == 0 {
-		i := strings.Index(domainInService, domainInCert[1:])
-		if i < 0 {
-			return false
+	base, isWildcard := strings.CutPrefix(domainInCert, "*.")
+	if !isWildcard || strings.Contains(base, "*") || !strings.Contains(base, ".") {
+		// partial wildcard like f*.example.com, or wildcard covering a top level domain
+		return false
+	}
+	label, rest, found := strings.Cut(domainInService, ".")
+	if !found || label == "" || strings.Contains(label, "*") {
+		return false
+	}
+	return rest == base
+}
+
+// MatchDomains checks whether any of domainsInCert matches domainInService
+func MatchDomains(domainsInCert []string, domainInService string) bool {
+	for _, domainInCert := range domainsInCert {
+		if MatchDomain(domainInCert, domainInService) {
+			return true
 		}
-		sub := domainInCert[0:i]
-		if strings.Contains(sub, ".") {
-			// don't match wildcard
+	}
+	return false
+}
+
+// CoverDomains checks whether every one of domainsInService is matched by domainsInCert
+func CoverDomains(domainsInCert, domainsInService []string) bool {
+	for _, domainInService := range domainsInService {
+		if !MatchDomains(domainsInCert, domainInService) {
 			return false
 		}
-	} else {
-		return domainInCert == domainInService
 	}
 	return true
 }
 
-func normalizeDomain(domain string) string {
-	return strings.ToLower(domain)
+// NormalizeDomain lower cases domain, removes its trailing dot and converts IDN to punycode
+func NormalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	prefix := ""
+	if rest, found := strings.CutPrefix(domain, "*."); found {
+		prefix, domain = "*.", rest
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		// keep domains idna refuses, such as ones with underscores, comparable
+		return prefix + strings.ToLower(domain)
+	}
+	return prefix + ascii
 }
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchDomain(t *testing.T) {
+	cases := []struct {
+		domainInCert    string
+		domainInService string
+		matched         bool
+	}{
+		{"example.com", "example.com", true},
+		{"Example.COM", "example.com", true},
+		{"example.com.", "example.com", true},
+		{"example.com", "example.com.", true},
+		{"example.com", "www.example.com", false},
+		{"www.example.com", "example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "WWW.Example.com.", true},
+		{"*.example.com.", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", ".example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "wwwexample.com", false},
+		{"*.example.com", "www.example.com.evil.net", false},
+		{"*.example.com", "example.com.evil.net", false},
+		{"*.example.com", "www.notexample.com", false},
+		{"*.example.com", "*.example.com", true},
+		{"*.example.com", "*.a.example.com", false},
+		{"*.a.example.com", "*.example.com", false},
+		{"*.com", "example.com", false},
+		{"*", "example.com", false},
+		{"w*.example.com", "www.example.com", false},
+		{"*w.example.com", "www.example.com", false},
+		{"www.*.example.com", "www.a.example.com", false},
+		{"*.*.example.com", "a.b.example.com", false},
+		{"bücher.example", "xn--bcher-kva.example", true},
+		{"xn--bcher-kva.example", "Bücher.example", true},
+		{"*.bücher.example", "www.xn--bcher-kva.example", true},
+		{"*.xn--bcher-kva.example", "shop.bücher.example", true},
+		{"bücher.example", "bucher.example", false},
+		{"_acme.example.com", "_acme.example.com", true},
+		{"", "", false},
+		{"example.com", "", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.matched, MatchDomain(c.domainInCert, c.domainInService), "%s matching %s", c.domainInCert, c.domainInService)
+	}
+}
+
+func TestCoverDomains(t *testing.T) {
+	cases := []struct {
+		domainsInCert    []string
+		domainsInService []string
+		covered          bool
+	}{
+		{[]string{"*.example.com"}, []string{"a.example.com"}, true},
+		{[]string{"*.example.com"}, []string{"foo.bar.example.com"}, false},
+		{[]string{"*.foo.com", "*.bar.com"}, []string{"foo.bar.com"}, true},
+		{[]string{"*.foo.com", "*.bar.com", "bar.com"}, []string{"foo.bar.com", "bar.foo.com", "bar.com"}, true},
+		{[]string{"*.foo.com", "*.bar.com", "bar.com"}, []string{"foo.bar.com", "bar.foo.com", "foo.com"}, false},
+		{[]string{"example.com"}, []string{}, true},
+		{[]string{}, []string{"example.com"}, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.covered, CoverDomains(c.domainsInCert, c.domainsInService), "%v covering %v", c.domainsInCert, c.domainsInService)
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	assert.Equal(t, "example.com", NormalizeDomain("Example.COM."))
+	assert.Equal(t, "*.example.com", NormalizeDomain("*.Example.com"))
+	assert.Equal(t, "xn--bcher-kva.example", NormalizeDomain("Bücher.example"))
+	assert.Equal(t, "*.xn--bcher-kva.example", NormalizeDomain("*.bücher.example."))
+	assert.Equal(t, "_acme.example.com", NormalizeDomain("_ACME.example.com"))
+}